@@ -11,18 +11,57 @@ type ServerOption struct {
 	DbPassword string
 	DbName     string
 	FileDir    string
+	// ReserveGrace 预约宽限时间（分钟）
+	ReserveGrace int
+	// PDFFont PDF报表使用的TTF字体文件
+	PDFFont string
+	// SMTP 定时报表邮件服务器
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+	SMTPFrom     string
+	// Storage 上传文件存储
+	Storage     string
+	StorageDir  string
+	S3Endpoint  string
+	S3AccessKey string
+	S3SecretKey string
+	S3Bucket    string
+	S3Region    string
+	S3UseSSL    bool
+	S3Presign   bool
+	// UploadPolicy 上传策略文件
+	UploadPolicy string
+	// MQTT 智能柜设备连接
+	MQTTBroker      string
+	MQTTUser        string
+	MQTTPassword    string
+	MQTTClientID    string
+	MQTTTopicPrefix string
+	// CabinetOffline 智能柜离线判定时间（分钟）
+	CabinetOffline int
+	// MigrateStorage 从该存储复制文件到当前配置的存储后退出
+	MigrateStorage string
 }
 
 // NewServerOption create a ServerOption object
 func NewServerOption() *ServerOption {
 	s := ServerOption{
-		AddrPort:   ":1323",
-		DbHost:     "127.0.0.1",
-		DbPort:     5432,
-		DbUser:     "postgres",
-		DbPassword: "12345678",
-		DbName:     "cmkit",
-		FileDir:    "./webfiles",
+		AddrPort:        ":1323",
+		DbHost:          "127.0.0.1",
+		DbPort:          5432,
+		DbUser:          "postgres",
+		DbPassword:      "12345678",
+		DbName:          "cmkit",
+		FileDir:         "./webfiles",
+		ReserveGrace:    30,
+		SMTPPort:        25,
+		Storage:         "local",
+		StorageDir:      "./storage",
+		S3Bucket:        "zone",
+		MQTTTopicPrefix: "zone",
+		CabinetOffline:  5,
 	}
 
 	return &s
@@ -36,5 +75,29 @@ func (s *ServerOption) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&s.DbUser, "dbuser", "postgres", "The db user")
 	fs.StringVar(&s.DbPassword, "dbpassword", "12345678", "The db password")
 	fs.StringVar(&s.DbName, "dbname", "cmkit", "The db name")
-	fs.StringVar(&s.FileDir, "filedir", "./webfiles", "The filedir for upload")
+	fs.StringVar(&s.FileDir, "filedir", "./webfiles", "The directory of legacy web assets, uploaded files are kept in storagedir")
+	fs.IntVar(&s.ReserveGrace, "reservegrace", 30, "The minutes a reservation is kept after its start time before it expires")
+	fs.StringVar(&s.PDFFont, "pdffont", "", "The TTF font file with Chinese glyphs used by PDF reports")
+	fs.StringVar(&s.SMTPHost, "smtphost", "", "The SMTP server for scheduled report mails")
+	fs.IntVar(&s.SMTPPort, "smtpport", 25, "The SMTP server port, 465 uses implicit TLS")
+	fs.StringVar(&s.SMTPUser, "smtpuser", "", "The SMTP user")
+	fs.StringVar(&s.SMTPPassword, "smtppassword", "", "The SMTP password")
+	fs.StringVar(&s.SMTPFrom, "smtpfrom", "", "The sender address of report mails, defaults to the SMTP user")
+	fs.StringVar(&s.Storage, "storage", "local", "The storage for uploaded files, local or s3")
+	fs.StringVar(&s.StorageDir, "storagedir", "./storage", "The directory of the local storage, must not be served as static files")
+	fs.StringVar(&s.S3Endpoint, "s3endpoint", "", "The S3 compatible endpoint, e.g. 127.0.0.1:9000")
+	fs.StringVar(&s.S3AccessKey, "s3accesskey", "", "The S3 access key")
+	fs.StringVar(&s.S3SecretKey, "s3secretkey", "", "The S3 secret key")
+	fs.StringVar(&s.S3Bucket, "s3bucket", "zone", "The S3 bucket, created if missing")
+	fs.StringVar(&s.S3Region, "s3region", "", "The S3 region")
+	fs.BoolVar(&s.S3UseSSL, "s3ssl", false, "Use HTTPS for the S3 endpoint")
+	fs.BoolVar(&s.S3Presign, "s3presign", false, "Redirect downloads to presigned S3 URLs instead of proxying them")
+	fs.StringVar(&s.UploadPolicy, "uploadpolicy", "", "The JSON file of upload policies by purpose, overriding the defaults")
+	fs.StringVar(&s.MQTTBroker, "mqttbroker", "", "The MQTT broker cabinets connect to, e.g. tcp://127.0.0.1:1883, empty to disable")
+	fs.StringVar(&s.MQTTUser, "mqttuser", "", "The MQTT user")
+	fs.StringVar(&s.MQTTPassword, "mqttpassword", "", "The MQTT password")
+	fs.StringVar(&s.MQTTClientID, "mqttclientid", "", "The MQTT client id, must be stable and unique per replica, defaults to zone-<hostname>")
+	fs.StringVar(&s.MQTTTopicPrefix, "mqtttopicprefix", "zone", "The prefix of cabinet MQTT topics")
+	fs.IntVar(&s.CabinetOffline, "cabinetoffline", 5, "The minutes without heartbeat after which a cabinet is considered offline")
+	fs.StringVar(&s.MigrateStorage, "migratestorage", "", "Copy uploaded files from the given storage (local, s3, or filedir for files stored by older versions) to the configured storage and exit")
 }
@@ -32,11 +32,7 @@ func Run(op *ServerOption) error {
 	// error handler
 	e.HTTPErrorHandler = httpErrorHandler
 
-	// static files directory
-	util.FileDir = op.FileDir
-	if !util.HasSuffix(util.FileDir, "/") {
-		util.FileDir = util.FileDir + "/"
-	}
+	setGlobals(op)
 
 	// db
 	db, err := util.InitDB(op.DbHost, op.DbUser, op.DbPassword, op.DbName, op.DbPort)
@@ -49,7 +45,20 @@ func Run(op *ServerOption) error {
 	// Service
 	svc := service.NewService()
 	svc.SetConfig(e, db)
+	// 数据迁移会导入旧文件，先初始化存储
+	if err := svc.InitStorage(); err != nil {
+		e.Logger.Fatal("Storage init failed.")
+		return err
+	}
+	if err := svc.Migrate(); err != nil {
+		e.Logger.Fatal("DB migrate failed.")
+		return err
+	}
 	svc.RegisterServices()
+	// scheduled jobs
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go svc.RunScheduler(jobCtx)
 
 	// Start server
 	go func() {
@@ -72,6 +81,51 @@ func Run(op *ServerOption) error {
 	return nil
 }
 
+// setGlobals 设置全局配置
+func setGlobals(op *ServerOption) {
+	// static files directory
+	util.FileDir = op.FileDir
+	if !util.HasSuffix(util.FileDir, "/") {
+		util.FileDir = util.FileDir + "/"
+	}
+
+	// reservation
+	util.ReservationGrace = time.Duration(op.ReserveGrace) * time.Minute
+	// report
+	util.PDFFont = op.PDFFont
+	util.SMTPHost, util.SMTPPort = op.SMTPHost, op.SMTPPort
+	util.SMTPUser, util.SMTPPassword, util.SMTPFrom = op.SMTPUser, op.SMTPPassword, op.SMTPFrom
+	// storage
+	util.StorageType, util.StorageDir = op.Storage, op.StorageDir
+	util.S3Endpoint, util.S3AccessKey, util.S3SecretKey = op.S3Endpoint, op.S3AccessKey, op.S3SecretKey
+	util.S3Bucket, util.S3Region, util.S3UseSSL, util.S3Presign = op.S3Bucket, op.S3Region, op.S3UseSSL, op.S3Presign
+	util.UploadPolicyFile = op.UploadPolicy
+	// cabinet devices
+	util.MQTTBroker, util.MQTTUser, util.MQTTPassword = op.MQTTBroker, op.MQTTUser, op.MQTTPassword
+	util.MQTTClientID, util.MQTTTopicPrefix = op.MQTTClientID, op.MQTTTopicPrefix
+	util.CabinetOfflineAfter = time.Duration(op.CabinetOffline) * time.Minute
+}
+
+// MigrateStorage 把上传文件从op.MigrateStorage指定的存储复制到当前配置的存储，返回复制和跳过的文件数
+func MigrateStorage(op *ServerOption) (int, int, error) {
+	setGlobals(op)
+	if op.MigrateStorage == op.Storage {
+		return 0, 0, fmt.Errorf("source and target storage are both %s", op.Storage)
+	}
+	db, err := util.InitDB(op.DbHost, op.DbUser, op.DbPassword, op.DbName, op.DbPort)
+	if err != nil {
+		return 0, 0, err
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+	svc := service.NewService()
+	svc.SetConfig(echo.New(), db)
+	if err := svc.InitStorage(); err != nil {
+		return 0, 0, err
+	}
+	return svc.MigrateStorage(op.MigrateStorage)
+}
+
 func httpErrorHandler(err error, c echo.Context) {
 	var (
 		code    = common.ERR_BAD_REQUEST
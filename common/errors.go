@@ -59,4 +59,62 @@ var (
 	ErrCabinetGridIsZero = errors.New("智能柜箱格数不能是0")
 	// ErrGridAlreadyInUse 箱格已使用
 	ErrGridAlreadyInUse = errors.New("箱格已使用")
+	// ErrGridNotFound 箱格不存在
+	ErrGridNotFound = errors.New("箱格不存在")
+	// ErrGridDisabled 箱格已停用或损坏
+	ErrGridDisabled = errors.New("箱格已停用或损坏")
+	// ErrCabinetGridInUse 缩减的箱格中存放有吊索具
+	ErrCabinetGridInUse = errors.New("缩减的箱格中存放有吊索具，请先移出")
+	// ErrNoFreeGrid 没有符合条件的空闲箱格
+	ErrNoFreeGrid = errors.New("没有符合条件的空闲箱格")
+	// ErrReservationTime 预约时间错误
+	ErrReservationTime = errors.New("预约时间错误")
+	// ErrReservationStaffIsNull 预约员工未选择
+	ErrReservationStaffIsNull = errors.New("预约员工未选择")
+	// ErrReservationConflict 预约时段内没有可用的吊索具
+	ErrReservationConflict = errors.New("预约时段内没有可用的吊索具")
+	// ErrSlingReserved 吊索具已被他人预约
+	ErrSlingReserved = errors.New("吊索具已被他人预约")
+	// ErrSlingUsePermission 吊索具使用权限错误
+	ErrSlingUsePermission = errors.New("吊索具使用权限错误")
+	// ErrSlingNotOut 吊索具未借出
+	ErrSlingNotOut = errors.New("吊索具未借出或已归还")
+	// ErrBorrowStaffIsNull 借用申请员工未选择
+	ErrBorrowStaffIsNull = errors.New("借用申请员工未选择")
+	// ErrBorrowExpired 借用申请已过期
+	ErrBorrowExpired = errors.New("借用申请已过期")
+	// ErrBorrowNoApprover 无权审批该借用申请
+	ErrBorrowNoApprover = errors.New("无权审批该借用申请")
+	// ErrBorrowNotApproved 吊索具借用未审批
+	ErrBorrowNotApproved = errors.New("吊索具借用需审批，没有已批准的借用申请")
+	// ErrCertTypeIsNull 证书类型不能为空
+	ErrCertTypeIsNull = errors.New("证书类型不能为空")
+	// ErrStaffNotQualified 员工无有效的资质证书
+	ErrStaffNotQualified = NewHTTPError(ERR_STAFF_NOT_QUALIFIED, "员工无有效的资质证书，不能借用该吊索具")
+	// ErrBorrowLimitExceeded 超出借用限额
+	ErrBorrowLimitExceeded = NewHTTPError(ERR_BORROW_LIMIT_EXCEEDED, "超出借用限额")
+	// ErrReportFormat 报表格式错误
+	ErrReportFormat = errors.New("报表格式错误，仅支持xlsx和pdf")
+	// ErrReportFontNotSet 未配置PDF字体
+	ErrReportFontNotSet = errors.New("未配置PDF报表字体")
+	// ErrReportSubscription 报表订阅设置错误
+	ErrReportSubscription = errors.New("报表订阅设置错误，请检查报表类型、发送时间和接收方")
+	// ErrNoPermission 无权操作
+	ErrNoPermission = NewHTTPError(ERR_NO_PERMISSION, "无权操作")
+	// ErrFileNotImage 文件不是图片
+	ErrFileNotImage = errors.New("文件不是支持的图片格式")
+	// ErrFileTooLarge 文件超过大小限制
+	ErrFileTooLarge = NewHTTPError(ERR_FILE_TOO_LARGE, "文件超过大小限制")
+	// ErrFileType 文件类型不允许
+	ErrFileType = NewHTTPError(ERR_FILE_TYPE, "不允许上传该类型的文件")
+	// ErrImageTooLarge 图片尺寸超过限制
+	ErrImageTooLarge = NewHTTPError(ERR_IMAGE_TOO_LARGE, "图片尺寸超过限制")
+	// ErrThumbnailBusy 生成缩略图的请求过多
+	ErrThumbnailBusy = errors.New("生成缩略图的请求过多，请稍后重试")
+	// ErrCommandFinished 指令已完成或已过期
+	ErrCommandFinished = errors.New("指令已完成或已过期")
+	// ErrDeviceEventTime 设备事件时间错误
+	ErrDeviceEventTime = errors.New("事件时间为空或晚于服务器时间")
+	// ErrDeviceEventStale 设备事件早于服务器已记录的借还
+	ErrDeviceEventStale = errors.New("事件时间早于服务器最近的借还记录")
 )
@@ -30,6 +30,18 @@ const (
 	ERR_INTERNAL_SERVER_ERROR int = 40003
 	// ERR_BAD_REQUEST 错误请求
 	ERR_BAD_REQUEST int = 40005
+	// ERR_STAFF_NOT_QUALIFIED 员工资质不符
+	ERR_STAFF_NOT_QUALIFIED int = 40101
+	// ERR_BORROW_LIMIT_EXCEEDED 超出借用限额
+	ERR_BORROW_LIMIT_EXCEEDED int = 40102
+	// ERR_NO_PERMISSION 无权操作
+	ERR_NO_PERMISSION int = 40103
+	// ERR_FILE_TOO_LARGE 文件超过大小限制
+	ERR_FILE_TOO_LARGE int = 40104
+	// ERR_FILE_TYPE 文件类型不允许
+	ERR_FILE_TYPE int = 40105
+	// ERR_IMAGE_TOO_LARGE 图片尺寸超过限制
+	ERR_IMAGE_TOO_LARGE int = 40106
 	// ERR_TOKEN_EXPIRED Token超时
 	ERR_TOKEN_EXPIRED int = 50014
 	// ERR_ILLEGAL_TOKEN 无效的Token
@@ -0,0 +1,188 @@
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+const (
+	// AttachmentPhoto 照片
+	AttachmentPhoto = "photo"
+	// AttachmentCertificate 出厂合格证
+	AttachmentCertificate = "certificate"
+	// AttachmentInspectionReport 检测报告
+	AttachmentInspectionReport = "inspection_report"
+)
+
+var attachmentCategories = map[string]bool{
+	AttachmentPhoto:            true,
+	AttachmentCertificate:      true,
+	AttachmentInspectionReport: true,
+}
+
+// entityTables 关联对象的表，用于检查对象是否存在
+var entityTables = map[string]string{
+	FileEntityStaff:     "t_sys_staff",
+	FileEntityStaffCert: "t_sys_staff_cert",
+	FileEntitySling:     "t_res_sling",
+	FileEntityCabinet:   "t_res_cabinet",
+}
+
+// listThumbSize 列表中照片缩略图的尺寸
+const listThumbSize = 128
+
+// Attachment 附件
+type Attachment struct {
+	FileObject
+	URL      string `json:"url"`      // 下载地址
+	ThumbURL string `json:"thumbUrl"` // 缩略图地址，非图片为空
+}
+
+// AttachmentParam 添加附件参数
+type AttachmentParam struct {
+	FileID     uint   `json:"fileId"`
+	EntityType string `json:"entityType"`
+	EntityID   uint   `json:"entityId"`
+	Category   string `json:"category"`
+}
+
+const (
+	// fileURLExpiry 文件签名地址的有效期
+	fileURLExpiry = time.Hour
+	// fileURLRound 过期时间按半小时取整，同一时段内地址不变，便于浏览器缓存
+	fileURLRound = 30 * time.Minute
+)
+
+// FileURL 文件下载地址，带过期时间和签名，img等无法设置请求头的场景不需要令牌
+func FileURL(fileID uint) string {
+	expires := time.Now().Truncate(fileURLRound).Add(fileURLExpiry).Unix()
+	return fmt.Sprintf("/file/%d?expires=%d&sig=%s", fileID, expires, fileSignature(fileID, expires))
+}
+
+// ThumbnailURL 缩略图地址
+func ThumbnailURL(fileID uint, size int) string {
+	return fmt.Sprintf("%s&thumb=%d", FileURL(fileID), size)
+}
+
+// fileSignature 文件ID和过期时间的签名
+func fileSignature(fileID uint, expires int64) string {
+	mac := hmac.New(sha256.New, util.SecretKey)
+	fmt.Fprintf(mac, "file:%d:%d", fileID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkFileSignature 检查签名地址是否有效
+func checkFileSignature(fileID uint, expires int64, sig string) bool {
+	if time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(fileSignature(fileID, expires)))
+}
+
+// checkAttachment 检查附件分类和关联对象，entityID为0时不检查对象
+func checkAttachment(db *gorm.DB, entityType string, entityID uint, category string) error {
+	if entityType != "" && !fileEntities[entityType] {
+		return common.ErrBadQueryParams
+	}
+	if category != "" && !attachmentCategories[category] {
+		return common.ErrBadQueryParams
+	}
+	table, ok := entityTables[entityType]
+	if !ok || entityID == 0 {
+		return nil
+	}
+	var count int64
+	if err := db.Table(table).Where("id = ? AND deleted_at IS NULL", entityID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.ErrNotFound
+	}
+	return nil
+}
+
+// ListAttachments 查询对象的附件，category为空时查询全部分类
+func (lgc *Logics) ListAttachments(entityType string, entityID uint, category string, userID uint) (*[]Attachment, error) {
+	if entityType == "" || entityID == 0 {
+		return nil, common.ErrBadQueryParams
+	}
+	if err := lgc.checkFileAccess(&FileObject{EntityType: entityType, EntityID: entityID}, userID); err != nil {
+		return nil, err
+	}
+	filedb := lgc.db.Where("entity_type = ? AND entity_id = ? AND deleted_at IS NULL", entityType, entityID).Order("id")
+	if category != "" {
+		filedb = filedb.Where("category = ?", category)
+	}
+	var files []FileObject
+	if err := filedb.Find(&files).Error; err != nil {
+		return nil, err
+	}
+	result := make([]Attachment, len(files))
+	for i, v := range files {
+		result[i] = Attachment{FileObject: v, URL: FileURL(v.ID)}
+		if thumbnailTypes[v.MimeType] {
+			result[i].ThumbURL = ThumbnailURL(v.ID, listThumbSize)
+		}
+	}
+	return &result, nil
+}
+
+// AddAttachment 已上传的文件作为对象的附件。须有对象的操作权限，只有上传用户和管理员可以关联，
+// 并按对象的上传策略检查文件类型和大小
+func (lgc *Logics) AddAttachment(param *AttachmentParam, userID uint) error {
+	if param.FileID == 0 || param.EntityType == "" || param.EntityID == 0 {
+		return common.ErrBadQueryParams
+	}
+	if err := checkAttachment(lgc.db, param.EntityType, param.EntityID, param.Category); err != nil {
+		return err
+	}
+	if err := checkEntityAccess(lgc.db, param.EntityType, param.EntityID, userID); err != nil {
+		return err
+	}
+	file, err := lgc.QueryFileByID(param.FileID)
+	if err != nil {
+		return err
+	}
+	if err := checkFileOwner(lgc.db, file, userID); err != nil {
+		return err
+	}
+	// 事务
+	tx := lgc.db.Begin()
+	if err := linkFile(tx, param.FileID, param.EntityType, param.EntityID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&FileObject{}).Where("id = ?", param.FileID).Update("category", param.Category).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// firstPhotos 对象的第一张照片，返回对象ID到文件ID
+func firstPhotos(db *gorm.DB, entityType string, entityIDs []uint) (map[uint]uint, error) {
+	result := map[uint]uint{}
+	if len(entityIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		EntityID uint
+		ID       uint
+	}
+	if err := db.Model(&FileObject{}).Select("entity_id, MIN(id) AS id").
+		Where("entity_type = ? AND entity_id IN ? AND category = ? AND deleted_at IS NULL", entityType, entityIDs, AttachmentPhoto).
+		Group("entity_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range rows {
+		result[v.EntityID] = v.ID
+	}
+	return result, nil
+}
@@ -0,0 +1,45 @@
+package logic
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"zone.com/common"
+)
+
+func TestAddAttachmentChecks(t *testing.T) {
+	fileRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "owner_id", "mime_type", "size"}).AddRow(9, 1, "application/pdf", 1024)
+	}
+
+	// 普通用户不能给其他员工添加附件
+	db, mock := newMockDB(t)
+	lgc := &Logics{db: db}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "t_sys_staff"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "r_auth_user_role"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT \* FROM "t_auth_user"`).WillReturnRows(sqlmock.NewRows([]string{"id", "staff_id"}).AddRow(2, 4))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "t_sys_department"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	err := lgc.AddAttachment(&AttachmentParam{FileID: 9, EntityType: FileEntityStaff, EntityID: 3}, 2)
+	if err != common.ErrNoPermission {
+		t.Errorf("other staff: err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// 管理员关联的文件也要符合对象的上传策略
+	db, mock = newMockDB(t)
+	lgc = &Logics{db: db}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "t_sys_staff"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT \* FROM "t_sys_file"`).WillReturnRows(fileRows())
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "t_sys_file"`).WillReturnRows(fileRows())
+	mock.ExpectRollback()
+	err = lgc.AddAttachment(&AttachmentParam{FileID: 9, EntityType: FileEntityStaff, EntityID: 3}, 1)
+	if err != common.ErrFileType {
+		t.Errorf("pdf as staff photo: err = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,262 @@
+package logic
+
+import (
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+// BorrowRequest 借用申请
+type BorrowRequest struct {
+	BaseModel
+	ResID         uint      `json:"resId"`
+	ResName       string    `json:"resName" gorm:"size:64"` // 资产名称
+	StaffID       uint      `json:"staffId"`
+	StaffName     string    `json:"staffName" gorm:"size:64"`         // 申请人姓名
+	DepartmentID  uint      `json:"departmentId"`                     // 申请人部门
+	Reason        string    `json:"reason"`                           // 申请原因
+	ExpireTime    JSONTime  `json:"expireTime" gorm:"type:timestamp"` // 有效期至
+	Status        int16     `json:"status"`                           // 状态：0-待审批，1-已批准，2-已驳回，3-已使用，4-已取消
+	ApproverID    uint      `json:"approverId"`                       // 审批用户
+	ApproverName  string    `json:"approverName" gorm:"size:64"`
+	ApproveTime   *JSONTime `json:"approveTime" gorm:"type:timestamp"` // 审批时间
+	ApproveRemark string    `json:"approveRemark"`                     // 审批意见
+	UseLogID      uint      `json:"useLogId"`                          // 使用的借还记录
+}
+
+// TableName BorrowRequest
+func (BorrowRequest) TableName() string {
+	return "t_res_borrow_request"
+}
+
+const (
+	// BorrowPending 待审批
+	BorrowPending int16 = 0
+	// BorrowApproved 已批准
+	BorrowApproved int16 = 1
+	// BorrowRejected 已驳回
+	BorrowRejected int16 = 2
+	// BorrowUsed 已使用
+	BorrowUsed int16 = 3
+	// BorrowCanceled 已取消
+	BorrowCanceled int16 = 4
+)
+
+// defaultBorrowExpire 借用申请默认有效期
+const defaultBorrowExpire = 24 * time.Hour
+
+// BorrowApproval 审批意见
+type BorrowApproval struct {
+	ID       uint   `json:"id"`
+	Approved bool   `json:"approved"`
+	Remark   string `json:"remark"`
+}
+
+// BorrowQueryParam 借用申请查询参数
+type BorrowQueryParam struct {
+	ResID   uint
+	StaffID uint
+	Status  int16 // -1-全部
+}
+
+// AddBorrowRequest 添加借用申请
+func (lgc *Logics) AddBorrowRequest(r *BorrowRequest) error {
+	sling, err := lgc.QuerySlingByID(r.ResID)
+	if err != nil {
+		return common.ErrNotFound
+	}
+	staff, err := lgc.QueryStaffByID(r.StaffID)
+	if err != nil {
+		return common.ErrBorrowStaffIsNull
+	}
+	now := time.Now()
+	if time.Time(r.ExpireTime).IsZero() {
+		r.ExpireTime = JSONTime(now.Add(defaultBorrowExpire))
+	}
+	if time.Time(r.ExpireTime).Before(now) {
+		return common.ErrBadQueryParams
+	}
+	// 重复申请
+	var count int64
+	if err := lgc.db.Model(&BorrowRequest{}).
+		Where("res_id = ? AND staff_id = ? AND status IN ? AND expire_time >= ?", r.ResID, r.StaffID, []int16{BorrowPending, BorrowApproved}, now).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.ErrAlreadyExists
+	}
+	r.ResName = sling.Name
+	r.StaffName = staff.Name
+	r.DepartmentID = staff.DepartmentID
+	r.Status = BorrowPending
+	r.ApproverID = 0
+	r.ApproverName = ""
+	r.ApproveTime = nil
+	r.UseLogID = 0
+	if err := lgc.db.Create(&r).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ApproveBorrowRequest 审批借用申请
+func (lgc *Logics) ApproveBorrowRequest(userID uint, approval *BorrowApproval) error {
+	var r BorrowRequest
+	if err := lgc.db.Where("id = ?", approval.ID).First(&r).Error; err != nil {
+		return common.ErrNotFound
+	}
+	if r.Status != BorrowPending {
+		return common.ErrNoUpdate
+	}
+	if time.Time(r.ExpireTime).Before(time.Now()) {
+		return common.ErrBorrowExpired
+	}
+	user, err := lgc.QueryUserByID(userID)
+	if err != nil {
+		return common.ErrUserNotFound
+	}
+	ok, err := lgc.canApprove(user, &r)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return common.ErrBorrowNoApprover
+	}
+	status := BorrowRejected
+	if approval.Approved {
+		status = BorrowApproved
+	}
+	now := JSONTime(time.Now())
+	data := map[string]interface{}{
+		"Status":        status,
+		"ApproverID":    user.ID,
+		"ApproverName":  user.Name,
+		"ApproveTime":   &now,
+		"ApproveRemark": approval.Remark,
+	}
+	if err := lgc.db.Model(&r).Updates(data).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// CancelBorrowRequest 取消借用申请，申请人、审批人或管理员可取消
+func (lgc *Logics) CancelBorrowRequest(id uint, userID uint) error {
+	var r BorrowRequest
+	if err := lgc.db.Where("id = ?", id).First(&r).Error; err != nil {
+		return common.ErrNotFound
+	}
+	user, err := lgc.QueryUserByID(userID)
+	if err != nil {
+		return common.ErrUserNotFound
+	}
+	allowed := user.StaffID > 0 && user.StaffID == r.StaffID
+	if !allowed {
+		if allowed, err = isAdmin(lgc.db, user.ID); err != nil {
+			return err
+		}
+	}
+	if !allowed {
+		if allowed, err = lgc.canApprove(user, &r); err != nil {
+			return err
+		}
+	}
+	if !allowed {
+		return common.ErrNoPermission
+	}
+	if r.Status != BorrowPending && r.Status != BorrowApproved {
+		return common.ErrNoUpdate
+	}
+	if err := lgc.db.Model(&r).Update("status", BorrowCanceled).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListBorrowRequests 查询借用申请
+func (lgc *Logics) ListBorrowRequests(param *BorrowQueryParam, pageIndex int, pageSize int) (*SearchResult, error) {
+	borrowdb := lgc.db.Model(&BorrowRequest{}).Order("created_at desc")
+	if param.ResID > 0 {
+		borrowdb = borrowdb.Where("res_id = ?", param.ResID)
+	}
+	if param.StaffID > 0 {
+		borrowdb = borrowdb.Where("staff_id = ?", param.StaffID)
+	}
+	if param.Status > -1 {
+		borrowdb = borrowdb.Where("status = ?", param.Status)
+	}
+	if pageIndex == 0 {
+		pageIndex = 1
+	}
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	var rowCount int64
+	borrowdb.Count(&rowCount)                                          //总行数
+	pageCount := int(math.Ceil(float64(rowCount) / float64(pageSize))) // 总页数
+
+	var requests []BorrowRequest
+	if err := borrowdb.Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&requests).Error; err != nil {
+		return nil, err
+	}
+
+	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &requests}, nil
+}
+
+// canApprove 审批人：默认用户、审批角色的用户或申请人的部门负责人
+func (lgc *Logics) canApprove(user *User, r *BorrowRequest) (bool, error) {
+	if user.ID == 1 {
+		return true, nil
+	}
+	var count int64
+	if err := lgc.db.Model(&Role{}).
+		Where("approver = 1 AND status = 0 AND id IN (SELECT role_id FROM r_auth_user_role WHERE user_id = ?)", user.ID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if user.StaffID == 0 || r.DepartmentID == 0 {
+		return false, nil
+	}
+	if err := lgc.db.Model(&Department{}).
+		Where("id = ? AND head_staff_id = ?", r.DepartmentID, user.StaffID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// checkBorrowApproval 需审批的吊索具必须有已批准且未过期的申请
+func checkBorrowApproval(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	if !sling.NeedApproval() {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&BorrowRequest{}).
+		Where("res_id = ? AND staff_id = ? AND status = ? AND expire_time >= ?", sling.ID, useLog.TakeStaffID, BorrowApproved, time.Now()).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.ErrBorrowNotApproved
+	}
+	return nil
+}
+
+// useBorrowApproval 借出时使用已批准的申请
+func useBorrowApproval(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	if !sling.NeedApproval() {
+		return nil
+	}
+	var r BorrowRequest
+	if err := db.Where("res_id = ? AND staff_id = ? AND status = ? AND expire_time >= ?", sling.ID, useLog.TakeStaffID, BorrowApproved, time.Now()).
+		Order("expire_time").First(&r).Error; err != nil {
+		return err
+	}
+	return db.Model(&r).Updates(map[string]interface{}{"Status": BorrowUsed, "UseLogID": useLog.ID}).Error
+}
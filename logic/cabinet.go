@@ -3,6 +3,7 @@ package logic
 import (
 	"math"
 
+	"gorm.io/gorm"
 	"zone.com/common"
 )
 
@@ -14,8 +15,16 @@ type Cabinet struct {
 	Location    string `json:"location"`
 	UsedCount   uint   `json:"usedCount" gorm:"-"`
 	UnUsedCount uint   `json:"unUsedCount" gorm:"-"`
-	Status      int16  `json:"status"` // 状态：0-正常
-	Remark      string `json:"remark"` // 说明
+	Status      int16  `json:"status"`            // 状态：0-正常
+	Remark      string `json:"remark"`            // 说明
+	PhotoURL    string `json:"photoUrl" gorm:"-"` // 第一张照片的缩略图
+	// 最近心跳上报的设备状态
+	OnlineStatus string    `json:"onlineStatus" gorm:"->;-:migration"` // online、offline、fault
+	LastSeenAt   *JSONTime `json:"lastSeenAt" gorm:"->;-:migration"`
+	Firmware     string    `json:"firmware" gorm:"->;-:migration"`
+	DoorState    string    `json:"doorState" gorm:"->;-:migration"`
+	Temperature  *float64  `json:"temperature" gorm:"->;-:migration"`
+	ErrorCodes   string    `json:"errorCodes" gorm:"->;-:migration"`
 }
 
 // TableName Cabinet
@@ -26,12 +35,25 @@ func (Cabinet) TableName() string {
 // CabinetGrid 智能柜箱格
 type CabinetGrid struct {
 	BaseModel
-	GridNo    uint `json:"gridNo"`    // 箱格编号
-	CabinetID uint `json:"cabinetId"` // 智能柜ID
-	InResID   uint `json:"inResId"`   // 存放的资产ID，空为0
-	IsOut     uint `json:"isOut"`     // 是否借出
+	GridNo     uint   `json:"gridNo"`     // 箱格编号
+	CabinetID  uint   `json:"cabinetId"`  // 智能柜ID
+	InResID    uint   `json:"inResId"`    // 存放的资产ID，空为0
+	IsOut      uint   `json:"isOut"`      // 是否借出
+	SizeClass  uint   `json:"sizeClass"`  // 尺寸等级，0-不限
+	MaxTonnage uint   `json:"maxTonnage"` // 最大承重吨位，0-不限
+	Status     int16  `json:"status"`     // 状态：0-正常，1-停用，2-损坏
+	Remark     string `json:"remark"`     // 说明
 }
 
+const (
+	// GridStatusNormal 箱格正常
+	GridStatusNormal int16 = 0
+	// GridStatusDisabled 箱格停用
+	GridStatusDisabled int16 = 1
+	// GridStatusBroken 箱格损坏
+	GridStatusBroken int16 = 2
+)
+
 // TableName CabinetGrid
 func (CabinetGrid) TableName() string {
 	return "t_res_cabinet_grid"
@@ -41,8 +63,10 @@ func (CabinetGrid) TableName() string {
 func (lgc *Logics) ListCabinets(name string, pageIndex int, pageSize int) (*SearchResult, error) {
 
 	cabinetdb := lgc.db.Table("t_res_cabinet").
-		Select("t_res_cabinet.*, COALESCE(t1.used_count, 0) AS used_count, COALESCE(t_res_cabinet.grid_count - t1.used_count, t_res_cabinet.grid_count) AS un_used_count").
+		Select("t_res_cabinet.*, COALESCE(t1.used_count, 0) AS used_count, COALESCE(t_res_cabinet.grid_count - t1.used_count, t_res_cabinet.grid_count) AS un_used_count, "+
+			cabinetStatusSQL+" AS online_status, cs.last_seen_at, cs.firmware, cs.door_state, cs.temperature, cs.error_codes", offlineCutoff()).
 		Joins("LEFT JOIN (SELECT t_res_cabinet_grid.cabinet_id, COUNT(0) AS used_count FROM t_res_cabinet_grid WHERE t_res_cabinet_grid.in_res_id > 0 AND t_res_cabinet_grid.deleted_at IS NULL GROUP BY cabinet_id) t1 ON t1.cabinet_id = t_res_cabinet.id").
+		Joins("LEFT JOIN t_res_cabinet_state cs ON cs.cabinet_id = t_res_cabinet.id").
 		Where("t_res_cabinet.deleted_at IS NULL")
 	if name != "" {
 		cabinetdb = cabinetdb.Where("t_res_cabinet.name LIKE ?", "%"+name+"%")
@@ -61,6 +85,19 @@ func (lgc *Logics) ListCabinets(name string, pageIndex int, pageSize int) (*Sear
 	if err := cabinetdb.Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&cabinets).Error; err != nil {
 		return nil, err
 	}
+	ids := make([]uint, len(cabinets))
+	for i, v := range cabinets {
+		ids[i] = v.ID
+	}
+	photos, err := firstPhotos(lgc.db, FileEntityCabinet, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range cabinets {
+		if photo, ok := photos[v.ID]; ok {
+			cabinets[i].PhotoURL = ThumbnailURL(photo, listThumbSize)
+		}
+	}
 
 	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &cabinets}, nil
 }
@@ -81,9 +118,18 @@ func (lgc *Logics) AddCabinet(cabinet *Cabinet) error {
 	if cabinet0 != nil {
 		return common.ErrCabinetAlreadyExists
 	}
-	if err := lgc.db.Create(&cabinet).Error; err != nil {
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Create(&cabinet).Error; err != nil {
+		tx.Rollback()
 		return err
 	}
+	// 生成箱格
+	if err := provisionGrids(tx, cabinet.ID, cabinet.GridCount); err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
 	return nil
 }
 
@@ -126,9 +172,18 @@ func (lgc *Logics) UpdateCabinet(cabinet *Cabinet) error {
 	if cabinet0 != nil && cabinet0.ID != cabinet.ID {
 		return common.ErrCabinetAlreadyExists
 	}
-	if err := lgc.db.Save(&cabinet).Error; err != nil {
+	// 事务
+	tx := lgc.db.Begin()
+	// 调整箱格数量
+	if err := resizeGrids(tx, cabinet.ID, cabinet.GridCount); err != nil {
+		tx.Rollback()
 		return err
 	}
+	if err := tx.Save(&cabinet).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.Commit()
 	return nil
 }
 
@@ -146,6 +201,11 @@ func (lgc *Logics) DeleteCabinet(id uint) error {
 		tx.Rollback()
 		return err
 	}
+	// 删除设备令牌
+	if err := tx.Where("cabinet_id = ?", id).Delete(&CabinetToken{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
 	tx.Commit()
 	return nil
 }
@@ -159,33 +219,87 @@ func (lgc *Logics) ListCabinetGrids(cabinetID uint) (*SearchResult, error) {
 		return nil, common.ErrNotFound
 	}
 
-	// 在用
-	griddb := lgc.db.Model(&CabinetGrid{})
-	if cabinetID > 0 {
-		griddb = griddb.Where("cabinet_id = ?", cabinetID)
-	}
-
 	var grids []CabinetGrid
-	if err := griddb.Find(&grids).Error; err != nil {
+	if err := lgc.db.Where("cabinet_id = ? AND grid_no <= ?", cabinetID, cabinet.GridCount).
+		Order("grid_no").Find(&grids).Error; err != nil {
 		return nil, err
 	}
 
-	result := make([]CabinetGrid, cabinet.GridCount)
-	for i := 0; i < int(cabinet.GridCount); i++ {
-		flag := false
-		for _, v := range grids {
-			if int(v.GridNo) == i+1 { // 已使用
-				data := &CabinetGrid{GridNo: uint(i + 1), CabinetID: cabinetID, InResID: v.InResID}
-				result[i] = *data
-				flag = true
-				break
-			}
+	return &SearchResult{Total: int64(len(grids)), PageIndex: 0, PageSize: 0, PageCount: 0, List: &grids}, nil
+}
+
+// UpdateCabinetGrid 修改箱格属性
+func (lgc *Logics) UpdateCabinetGrid(grid *CabinetGrid) error {
+	var grid0 CabinetGrid
+	if err := lgc.db.Where("cabinet_id = ? AND grid_no = ?", grid.CabinetID, grid.GridNo).First(&grid0).Error; err != nil {
+		return common.ErrGridNotFound
+	}
+	// 存放有吊索具的箱格不能停用
+	if grid.Status != GridStatusNormal && grid0.InResID > 0 {
+		return common.ErrGridAlreadyInUse
+	}
+	data := map[string]interface{}{
+		"SizeClass":  grid.SizeClass,
+		"MaxTonnage": grid.MaxTonnage,
+		"Status":     grid.Status,
+		"Remark":     grid.Remark,
+	}
+	if err := lgc.db.Model(&grid0).Updates(data).Error; err != nil {
+		return err
+	}
+	lgc.bus.Publish(TopicGridChanged, &grid0)
+	return nil
+}
+
+// provisionAllGrids 生成所有智能柜缺少的箱格，箱格只在迁移和添加、修改智能柜时生成
+func (lgc *Logics) provisionAllGrids() error {
+	var cabinets []Cabinet
+	if err := lgc.db.Where("deleted_at IS NULL").Find(&cabinets).Error; err != nil {
+		return err
+	}
+	for _, cabinet := range cabinets {
+		if err := provisionGrids(lgc.db, cabinet.ID, cabinet.GridCount); err != nil {
+			return err
 		}
-		if !flag { // 未使用
-			data := &CabinetGrid{GridNo: uint(i + 1), CabinetID: cabinetID, InResID: 0}
-			result[i] = *data
+	}
+	return nil
+}
+
+// provisionGrids 生成智能柜1~gridCount号箱格，已存在的箱格不变
+func provisionGrids(db *gorm.DB, cabinetID uint, gridCount uint) error {
+	var gridNos []uint
+	if err := db.Model(&CabinetGrid{}).Where("cabinet_id = ?", cabinetID).Pluck("grid_no", &gridNos).Error; err != nil {
+		return err
+	}
+	exists := make(map[uint]bool, len(gridNos))
+	for _, no := range gridNos {
+		exists[no] = true
+	}
+	var grids []CabinetGrid
+	for no := uint(1); no <= gridCount; no++ {
+		if !exists[no] {
+			grids = append(grids, CabinetGrid{GridNo: no, CabinetID: cabinetID})
 		}
 	}
+	if len(grids) == 0 {
+		return nil
+	}
+	return db.Create(&grids).Error
+}
 
-	return &SearchResult{Total: int64(len(result)), PageIndex: 0, PageSize: 0, PageCount: 0, List: &result}, nil
+// resizeGrids 调整智能柜箱格数量，新增的箱格自动生成，存放有吊索具的箱格不允许删除
+func resizeGrids(db *gorm.DB, cabinetID uint, gridCount uint) error {
+	var usedCount int64
+	if err := db.Model(&CabinetGrid{}).
+		Where("cabinet_id = ? AND grid_no > ? AND in_res_id > 0", cabinetID, gridCount).
+		Count(&usedCount).Error; err != nil {
+		return err
+	}
+	if usedCount > 0 {
+		return common.ErrCabinetGridInUse
+	}
+	if err := db.Where("cabinet_id = ? AND grid_no > ?", cabinetID, gridCount).Delete(&CabinetGrid{}).Error; err != nil {
+		return err
+	}
+	return provisionGrids(db, cabinetID, gridCount)
 }
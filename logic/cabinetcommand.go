@@ -0,0 +1,300 @@
+package logic
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"zone.com/common"
+)
+
+// CabinetCommand 发送给智能柜的指令，按智能柜排队，通过MQTT推送或设备长轮询获取
+type CabinetCommand struct {
+	BaseModel
+	CabinetID    uint      `json:"cabinetId" gorm:"index"`
+	Command      string    `json:"command" gorm:"size:32"`
+	GridNo       uint      `json:"gridNo"`  // 箱格指令的箱格
+	StaffID      uint      `json:"staffId"` // 代为操作的员工，如忘带卡时远程开箱格
+	StaffName    string    `json:"staffName" gorm:"size:64"`
+	Reason       string    `json:"reason"`                            // 原因
+	Status       int16     `json:"status"`                            // 状态：0-排队，1-已下发，2-已确认，3-失败，4-已过期
+	ExpireTime   JSONTime  `json:"expireTime" gorm:"type:timestamp"`  // 超过该时间未确认即过期
+	DeliverTime  *JSONTime `json:"deliverTime" gorm:"type:timestamp"` // 下发时间
+	FinishTime   *JSONTime `json:"finishTime" gorm:"type:timestamp"`  // 确认、失败或过期时间
+	Result       string    `json:"result"`                            // 设备返回的失败原因
+	OperatorID   uint      `json:"operatorId"`                        // 操作用户
+	OperatorName string    `json:"operatorName" gorm:"size:64"`
+	Timeout      int       `json:"timeout,omitempty" gorm:"-"` // 创建时指定的超时秒数
+}
+
+// TableName CabinetCommand
+func (CabinetCommand) TableName() string {
+	return "t_res_cabinet_command"
+}
+
+const (
+	// CommandQueued 排队
+	CommandQueued int16 = 0
+	// CommandDelivered 已下发
+	CommandDelivered int16 = 1
+	// CommandAcked 已确认
+	CommandAcked int16 = 2
+	// CommandFailed 失败
+	CommandFailed int16 = 3
+	// CommandExpired 已过期
+	CommandExpired int16 = 4
+)
+
+const (
+	// defaultCommandTimeout 指令默认超时时间
+	defaultCommandTimeout = 2 * time.Minute
+	// maxCommandTimeout 指令最长超时时间
+	maxCommandTimeout = time.Hour
+)
+
+// commandNames 指令名称，用于记录事件
+var commandNames = map[string]string{
+	CommandOpenDoor:   "远程开箱格",
+	CommandLockGrid:   "锁定箱格",
+	CommandUnlockGrid: "解锁箱格",
+	CommandSync:       "同步配置",
+	CommandReboot:     "重启",
+}
+
+var gridCommands = map[string]bool{CommandOpenDoor: true, CommandLockGrid: true, CommandUnlockGrid: true}
+
+// canOperateCabinet 管理员或拥有远程操作智能柜角色的用户
+func canOperateCabinet(db *gorm.DB, userID uint) (bool, error) {
+	admin, err := isAdmin(db, userID)
+	if err != nil || admin {
+		return admin, err
+	}
+	var count int64
+	if err := db.Model(&Role{}).
+		Where("cabinet_operator = 1 AND status = 0 AND id IN (SELECT role_id FROM r_auth_user_role WHERE user_id = ?)", userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// commandEvent 指令的事件记录
+func commandEvent(cmd *CabinetCommand, content string) *ResEvent {
+	return &ResEvent{EventType: EventCommand, CabinetID: cmd.CabinetID, GridNo: cmd.GridNo,
+		StaffID: cmd.StaffID, StaffName: cmd.StaffName,
+		Content: fmt.Sprintf("%s（指令%d）%s", commandNames[cmd.Command], cmd.ID, content)}
+}
+
+// SendCabinetCommand 指令加入智能柜的队列并发布，由设备连接服务推送给设备
+func (lgc *Logics) SendCabinetCommand(cmd *CabinetCommand, userID uint) error {
+	ok, err := canOperateCabinet(lgc.db, userID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return common.ErrNoPermission
+	}
+	cabinet, err := lgc.QueryCabinetByID(cmd.CabinetID)
+	if err != nil {
+		return common.ErrNotFound
+	}
+	if _, ok := commandNames[cmd.Command]; !ok {
+		return common.ErrBadQueryParams
+	}
+	if !gridCommands[cmd.Command] {
+		cmd.GridNo = 0
+	} else if cmd.GridNo == 0 || cmd.GridNo > cabinet.GridCount {
+		return common.ErrGridNotFound
+	}
+	if cmd.StaffID > 0 {
+		var staff Staff
+		if err := lgc.db.Where("id = ?", cmd.StaffID).First(&staff).Error; err != nil {
+			return common.ErrNotFound
+		}
+		cmd.StaffName = staff.Name
+	}
+	user, err := lgc.QueryUserByID(userID)
+	if err != nil {
+		return common.ErrUserNotFound
+	}
+	timeout := time.Duration(cmd.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultCommandTimeout
+	}
+	if timeout > maxCommandTimeout {
+		timeout = maxCommandTimeout
+	}
+	cmd.ID = 0
+	cmd.Status = CommandQueued
+	cmd.ExpireTime = JSONTime(time.Now().Add(timeout))
+	cmd.DeliverTime, cmd.FinishTime, cmd.Result = nil, nil, ""
+	cmd.OperatorID, cmd.OperatorName = user.ID, user.Name
+
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Create(cmd).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	content := "已排队，操作用户：" + user.Name
+	if cmd.Reason != "" {
+		content += "，原因：" + cmd.Reason
+	}
+	if err := recordEvent(tx, commandEvent(cmd, content)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	lgc.bus.Publish(TopicCabinetCommand, cmd)
+	return nil
+}
+
+// PendingCabinetCommands 排队中未过期的指令，cabinetID为0时查询全部智能柜
+func (lgc *Logics) PendingCabinetCommands(cabinetID uint) ([]CabinetCommand, error) {
+	var cmds []CabinetCommand
+	cmddb := lgc.db.Where("status = ? AND expire_time > ? AND deleted_at IS NULL", CommandQueued, time.Now())
+	if cabinetID > 0 {
+		cmddb = cmddb.Where("cabinet_id = ?", cabinetID)
+	}
+	if err := cmddb.Order("id").Find(&cmds).Error; err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// MarkCabinetCommandDelivered 指令已推送给设备
+func (lgc *Logics) MarkCabinetCommandDelivered(id uint) error {
+	now := JSONTime(time.Now())
+	return lgc.db.Model(&CabinetCommand{}).Where("id = ? AND status = ?", id, CommandQueued).
+		Updates(map[string]interface{}{"Status": CommandDelivered, "DeliverTime": &now}).Error
+}
+
+// DeliverCabinetCommands 设备长轮询获取指令，取出排队中的指令并标记为已下发
+func (lgc *Logics) DeliverCabinetCommands(cabinetID uint) ([]CabinetCommand, error) {
+	if _, err := lgc.QueryCabinetByID(cabinetID); err != nil {
+		return nil, common.ErrNotFound
+	}
+	var cmds []CabinetCommand
+	now := JSONTime(time.Now())
+	err := lgc.db.Transaction(func(tx *gorm.DB) error {
+		// 多个请求同时轮询时每条指令只下发一次
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("cabinet_id = ? AND status = ? AND expire_time > ? AND deleted_at IS NULL", cabinetID, CommandQueued, time.Time(now)).
+			Order("id").Find(&cmds).Error; err != nil {
+			return err
+		}
+		if len(cmds) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(cmds))
+		for i := range cmds {
+			ids[i] = cmds[i].ID
+			cmds[i].Status, cmds[i].DeliverTime = CommandDelivered, &now
+		}
+		return tx.Model(&CabinetCommand{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"Status": CommandDelivered, "DeliverTime": &now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// AckCabinetCommand 设备返回指令执行结果，errMsg为空表示成功
+func (lgc *Logics) AckCabinetCommand(cabinetID uint, id uint, errMsg string) error {
+	var cmd CabinetCommand
+	if err := lgc.db.Where("id = ? AND cabinet_id = ? AND deleted_at IS NULL", id, cabinetID).First(&cmd).Error; err != nil {
+		return common.ErrNotFound
+	}
+	if cmd.Status != CommandQueued && cmd.Status != CommandDelivered {
+		return common.ErrCommandFinished
+	}
+	if time.Time(cmd.ExpireTime).Before(time.Now()) {
+		if err := lgc.expireCabinetCommand(&cmd); err != nil {
+			return err
+		}
+		return common.ErrCommandFinished
+	}
+	status, content := CommandAcked, "已执行"
+	if errMsg != "" {
+		status, content = CommandFailed, "执行失败："+errMsg
+	}
+	return lgc.finishCabinetCommand(&cmd, status, errMsg, content)
+}
+
+// ExpireCabinetCommands 超时未确认的指令标记为过期，由主节点定时执行
+func (lgc *Logics) ExpireCabinetCommands() error {
+	var cmds []CabinetCommand
+	if err := lgc.db.Where("status IN ? AND expire_time < ? AND deleted_at IS NULL", []int16{CommandQueued, CommandDelivered}, time.Now()).
+		Find(&cmds).Error; err != nil {
+		return err
+	}
+	for i := range cmds {
+		// 期间设备已返回结果的不处理
+		if err := lgc.expireCabinetCommand(&cmds[i]); err != nil && err != common.ErrCommandFinished {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lgc *Logics) expireCabinetCommand(cmd *CabinetCommand) error {
+	content := "超时未确认，已过期"
+	if cmd.Status == CommandQueued {
+		content = "未下发，已过期"
+	}
+	return lgc.finishCabinetCommand(cmd, CommandExpired, "", content)
+}
+
+// finishCabinetCommand 更新指令的最终状态并记录事件，指令已被其他请求完成时不处理
+func (lgc *Logics) finishCabinetCommand(cmd *CabinetCommand, status int16, result string, content string) error {
+	now := JSONTime(time.Now())
+	return lgc.db.Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&CabinetCommand{}).Where("id = ? AND status IN ?", cmd.ID, []int16{CommandQueued, CommandDelivered}).
+			Updates(map[string]interface{}{"Status": status, "FinishTime": &now, "Result": result})
+		if update.Error != nil {
+			return update.Error
+		}
+		if update.RowsAffected == 0 {
+			return common.ErrCommandFinished
+		}
+		cmd.Status, cmd.FinishTime, cmd.Result = status, &now, result
+		return recordEvent(tx, commandEvent(cmd, content))
+	})
+}
+
+// ListCabinetCommands 智能柜的指令记录，status小于0时查询全部状态
+func (lgc *Logics) ListCabinetCommands(cabinetID uint, status int, pageIndex int, pageSize int) (*SearchResult, error) {
+	cmddb := lgc.db.Model(&CabinetCommand{}).Where("cabinet_id = ? AND deleted_at IS NULL", cabinetID)
+	if status >= 0 {
+		cmddb = cmddb.Where("status = ?", status)
+	}
+	if pageIndex == 0 {
+		pageIndex = 1
+	}
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	var rowCount int64
+	cmddb.Count(&rowCount)                                             //总行数
+	pageCount := int(math.Ceil(float64(rowCount) / float64(pageSize))) // 总页数
+
+	var cmds []CabinetCommand
+	if err := cmddb.Order("id DESC").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&cmds).Error; err != nil {
+		return nil, err
+	}
+	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &cmds}, nil
+}
+
+// QueryCabinetCommand 查询指令状态
+func (lgc *Logics) QueryCabinetCommand(id uint) (*CabinetCommand, error) {
+	var cmd CabinetCommand
+	if err := lgc.db.Where("id = ? AND deleted_at IS NULL", id).First(&cmd).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	return &cmd, nil
+}
@@ -0,0 +1,171 @@
+package logic
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+const (
+	// CabinetOnline 在线
+	CabinetOnline = "online"
+	// CabinetOffline 离线，超过设定时间没有心跳
+	CabinetOffline = "offline"
+	// CabinetFault 在线但上报了错误
+	CabinetFault = "fault"
+)
+
+// CabinetState 智能柜最近一次心跳上报的状态
+type CabinetState struct {
+	CabinetID    uint      `json:"cabinetId" gorm:"primary_key;autoIncrement:false"`
+	OnlineStatus string    `json:"onlineStatus" gorm:"size:16"`      // 最近记录的在线状态
+	LastSeenAt   *JSONTime `json:"lastSeenAt" gorm:"type:timestamp"` // 最近心跳时间
+	Firmware     string    `json:"firmware" gorm:"size:64"`          // 固件版本
+	DoorState    string    `json:"doorState" gorm:"size:16"`         // 柜门状态：open、closed
+	Temperature  *float64  `json:"temperature"`                      // 温度
+	ErrorCodes   string    `json:"errorCodes" gorm:"size:255"`       // 错误码，多个用逗号分隔
+}
+
+// TableName CabinetState
+func (CabinetState) TableName() string {
+	return "t_res_cabinet_state"
+}
+
+// CabinetStatusLog 智能柜在线状态变化记录
+type CabinetStatusLog struct {
+	BaseModel
+	CabinetID  uint   `json:"cabinetId" gorm:"index"`
+	Status     string `json:"status" gorm:"size:16"`     // 变化后的状态
+	FromStatus string `json:"fromStatus" gorm:"size:16"` // 变化前的状态
+	ErrorCodes string `json:"errorCodes" gorm:"size:255"`
+	Remark     string `json:"remark"`
+}
+
+// TableName CabinetStatusLog
+func (CabinetStatusLog) TableName() string {
+	return "t_res_cabinet_status_log"
+}
+
+// CabinetHeartbeat 智能柜心跳内容
+type CabinetHeartbeat struct {
+	Firmware    string   `json:"firmware"`
+	DoorState   string   `json:"doorState"`
+	Temperature *float64 `json:"temperature"`
+	ErrorCodes  []string `json:"errorCodes"`
+}
+
+// cabinetStatusSQL 按最近心跳时间计算的在线状态，参数为离线判定时间
+const cabinetStatusSQL = "CASE WHEN cs.last_seen_at IS NULL OR cs.last_seen_at < ? THEN 'offline' " +
+	"WHEN COALESCE(cs.error_codes, '') <> '' THEN 'fault' ELSE 'online' END"
+
+// offlineCutoff 早于该时间没有心跳的智能柜视为离线
+func offlineCutoff() time.Time {
+	return time.Now().Add(-util.CabinetOfflineAfter)
+}
+
+// RecordHeartbeat 记录心跳，在线状态变化时记录
+func (lgc *Logics) RecordHeartbeat(cabinetID uint, hb *CabinetHeartbeat) error {
+	if _, err := lgc.QueryCabinetByID(cabinetID); err != nil {
+		return common.ErrNotFound
+	}
+	now := JSONTime(time.Now())
+	state := CabinetState{CabinetID: cabinetID, OnlineStatus: CabinetOnline, LastSeenAt: &now,
+		Firmware: hb.Firmware, DoorState: hb.DoorState, Temperature: hb.Temperature,
+		ErrorCodes: strings.Join(hb.ErrorCodes, ",")}
+	if state.ErrorCodes != "" {
+		state.OnlineStatus = CabinetFault
+	}
+
+	// 事务
+	tx := lgc.db.Begin()
+	var old CabinetState
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cabinet_id = ?", cabinetID).
+		Limit(1).Find(&old).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&state).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	from := old.OnlineStatus
+	if from == "" {
+		from = CabinetOffline
+	}
+	if from != state.OnlineStatus {
+		log := CabinetStatusLog{CabinetID: cabinetID, Status: state.OnlineStatus, FromStatus: from, ErrorCodes: state.ErrorCodes}
+		if err := tx.Create(&log).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// MarkOfflineCabinets 超过设定时间没有心跳的智能柜标记为离线并记录，由主节点定时执行
+func (lgc *Logics) MarkOfflineCabinets() error {
+	var states []CabinetState
+	if err := lgc.db.Where("online_status <> ? AND last_seen_at < ?", CabinetOffline, offlineCutoff()).
+		Find(&states).Error; err != nil {
+		return err
+	}
+	for _, v := range states {
+		if err := lgc.db.Transaction(func(tx *gorm.DB) error {
+			// 期间收到心跳时不处理
+			result := tx.Model(&CabinetState{}).
+				Where("cabinet_id = ? AND online_status <> ? AND last_seen_at < ?", v.CabinetID, CabinetOffline, offlineCutoff()).
+				Update("online_status", CabinetOffline)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			return tx.Create(&CabinetStatusLog{CabinetID: v.CabinetID, Status: CabinetOffline, FromStatus: v.OnlineStatus,
+				Remark: "超过" + util.CabinetOfflineAfter.String() + "没有心跳"}).Error
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishCabinetStatus 发布时间段内的在线状态变化，变为离线时另发布离线提醒。
+// 状态变化可能由其他副本记录，每个副本定时执行以推送给各自连接的客户端
+func (lgc *Logics) PublishCabinetStatus(since time.Time, until time.Time) error {
+	var logs []CabinetStatusLog
+	if err := lgc.db.Where("created_at >= ? AND created_at < ?", since, until).
+		Order("created_at, id").Find(&logs).Error; err != nil {
+		return err
+	}
+	for i := range logs {
+		lgc.bus.Publish(TopicCabinetStatus, &logs[i])
+		if logs[i].Status == CabinetOffline {
+			lgc.bus.Publish(TopicCabinetOffline, &logs[i])
+		}
+	}
+	return nil
+}
+
+// ListCabinetStatusLogs 智能柜在线状态变化记录
+func (lgc *Logics) ListCabinetStatusLogs(cabinetID uint, pageIndex int, pageSize int) (*SearchResult, error) {
+	logdb := lgc.db.Model(&CabinetStatusLog{}).Where("cabinet_id = ? AND deleted_at IS NULL", cabinetID)
+	if pageIndex == 0 {
+		pageIndex = 1
+	}
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	var rowCount int64
+	logdb.Count(&rowCount)                                             //总行数
+	pageCount := int(math.Ceil(float64(rowCount) / float64(pageSize))) // 总页数
+
+	var logs []CabinetStatusLog
+	if err := logdb.Order("created_at DESC, id DESC").Offset((pageIndex - 1) * pageSize).Limit(pageSize).
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &logs}, nil
+}
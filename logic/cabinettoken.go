@@ -0,0 +1,78 @@
+package logic
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm/clause"
+	"zone.com/common"
+)
+
+// CabinetToken 智能柜的设备令牌，设备调用上报和获取指令的接口时使用，只保存令牌的哈希
+type CabinetToken struct {
+	CabinetID    uint     `json:"cabinetId" gorm:"primary_key;autoIncrement:false"`
+	TokenHash    string   `json:"-" gorm:"size:64"`
+	IssuedAt     JSONTime `json:"issuedAt" gorm:"type:timestamp"` // 签发时间
+	OperatorID   uint     `json:"operatorId"`                     // 签发用户
+	OperatorName string   `json:"operatorName" gorm:"size:64"`
+}
+
+// TableName CabinetToken
+func (CabinetToken) TableName() string {
+	return "t_res_cabinet_token"
+}
+
+func hashCabinetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueCabinetToken 管理员签发智能柜的设备令牌，已有的令牌立即失效。令牌只在签发时返回
+func (lgc *Logics) IssueCabinetToken(cabinetID uint, userID uint) (string, error) {
+	admin, err := isAdmin(lgc.db, userID)
+	if err != nil {
+		return "", err
+	}
+	if !admin {
+		return "", common.ErrNoPermission
+	}
+	if _, err := lgc.QueryCabinetByID(cabinetID); err != nil {
+		return "", common.ErrNotFound
+	}
+	user, err := lgc.QueryUserByID(userID)
+	if err != nil {
+		return "", common.ErrUserNotFound
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	record := &CabinetToken{CabinetID: cabinetID, TokenHash: hashCabinetToken(token), IssuedAt: JSONTime(time.Now()),
+		OperatorID: user.ID, OperatorName: user.Name}
+	if err := lgc.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cabinet_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token_hash", "issued_at", "operator_id", "operator_name"}),
+	}).Create(record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CheckCabinetToken 令牌是否为该智能柜签发的令牌
+func (lgc *Logics) CheckCabinetToken(cabinetID uint, token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+	var records []CabinetToken
+	if err := lgc.db.Where("cabinet_id = ?", cabinetID).Limit(1).Find(&records).Error; err != nil {
+		return false, err
+	}
+	if len(records) == 0 {
+		return false, nil
+	}
+	return subtle.ConstantTimeCompare([]byte(hashCabinetToken(token)), []byte(records[0].TokenHash)) == 1, nil
+}
@@ -0,0 +1,192 @@
+package logic
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+// StaffCert 员工资质证书
+type StaffCert struct {
+	BaseModel
+	StaffID    uint      `json:"staffId"`
+	StaffName  string    `json:"staffName" gorm:"->;-:migration"`
+	CertType   uint      `json:"certType"`                         // 证书类型
+	Level      uint      `json:"level"`                            // 等级
+	CertNo     string    `json:"certNo" gorm:"size:64"`            // 证书编号
+	IssueDate  *JSONTime `json:"issueDate" gorm:"type:timestamp"`  // 发证日期
+	ExpireDate *JSONTime `json:"expireDate" gorm:"type:timestamp"` // 有效期至
+	CertFile   string    `json:"certFile"`                         // 证书文件
+	CertFileID uint      `json:"certFileId"`                       // 证书文件
+	Remark     string    `json:"remark"`                           // 说明
+}
+
+// TableName StaffCert
+func (StaffCert) TableName() string {
+	return "t_sys_staff_cert"
+}
+
+// CertRule 吊索具借用资质要求
+type CertRule struct {
+	ID         uint   `json:"id" gorm:"primary_key"`
+	SlingType  uint   `json:"slingType"`  // 吊索具类型，0-全部
+	MinTonnage uint   `json:"minTonnage"` // 吊索具吨位达到该值时适用
+	CertType   uint   `json:"certType"`   // 要求的证书类型
+	MinLevel   uint   `json:"minLevel"`   // 要求的最低等级
+	Remark     string `json:"remark"`     // 说明
+}
+
+// TableName CertRule
+func (CertRule) TableName() string {
+	return "t_res_cert_rule"
+}
+
+// ListStaffCerts 查询员工证书
+func (lgc *Logics) ListStaffCerts(staffID uint) (*[]StaffCert, error) {
+	var certs []StaffCert
+	if err := lgc.db.Where("staff_id = ?", staffID).Order("cert_type, level desc").Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return &certs, nil
+}
+
+// AddStaffCert 添加员工证书
+func (lgc *Logics) AddStaffCert(cert *StaffCert, userID uint) error {
+	if err := checkStaffCert(cert); err != nil {
+		return err
+	}
+	if _, err := lgc.QueryStaffByID(cert.StaffID); err != nil {
+		return common.ErrNotFound
+	}
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Create(&cert).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := linkFile(tx, cert.CertFileID, FileEntityStaffCert, cert.ID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// UpdateStaffCert 修改员工证书
+func (lgc *Logics) UpdateStaffCert(cert *StaffCert, userID uint) error {
+	if err := checkStaffCert(cert); err != nil {
+		return err
+	}
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Save(&cert).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := linkFile(tx, cert.CertFileID, FileEntityStaffCert, cert.ID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// DeleteStaffCert 删除员工证书
+func (lgc *Logics) DeleteStaffCert(id uint) error {
+	if err := lgc.db.Where("id = ?", id).Delete(&StaffCert{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func checkStaffCert(cert *StaffCert) error {
+	if cert.StaffID == 0 || cert.CertType == 0 {
+		return common.ErrCertTypeIsNull
+	}
+	if cert.IssueDate != nil && cert.ExpireDate != nil &&
+		time.Time(*cert.ExpireDate).Before(time.Time(*cert.IssueDate)) {
+		return common.ErrBadQueryParams
+	}
+	return nil
+}
+
+// ListCertAlerts 查询days天内到期或已过期的证书
+func (lgc *Logics) ListCertAlerts(days int) (*[]StaffCert, error) {
+	var certs []StaffCert
+	if err := lgc.db.Table("t_sys_staff_cert").
+		Select("t_sys_staff_cert.*, t_sys_staff.name AS staff_name").
+		Joins("JOIN t_sys_staff ON t_sys_staff.id = t_sys_staff_cert.staff_id").
+		Where("t_sys_staff.deleted_at IS NULL AND t_sys_staff_cert.deleted_at IS NULL").
+		Where("t_sys_staff_cert.expire_date < ?", time.Now().AddDate(0, 0, days)).
+		Order("t_sys_staff_cert.expire_date").
+		Find(&certs).Error; err != nil {
+		return nil, err
+	}
+	return &certs, nil
+}
+
+// ListCertRules 查询资质要求
+func (lgc *Logics) ListCertRules() (*[]CertRule, error) {
+	var rules []CertRule
+	if err := lgc.db.Order("sling_type, min_tonnage").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// AddCertRule 添加资质要求
+func (lgc *Logics) AddCertRule(rule *CertRule) error {
+	if rule.CertType == 0 {
+		return common.ErrCertTypeIsNull
+	}
+	if err := lgc.db.Create(&rule).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateCertRule 修改资质要求
+func (lgc *Logics) UpdateCertRule(rule *CertRule) error {
+	if rule.CertType == 0 {
+		return common.ErrCertTypeIsNull
+	}
+	if err := lgc.db.Save(&rule).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteCertRule 删除资质要求
+func (lgc *Logics) DeleteCertRule(id uint) error {
+	if err := lgc.db.Where("id = ?", id).Delete(&CertRule{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkStaffQualification 借用人借出时须具备吊索具类型和吨位要求的有效证书
+func checkStaffQualification(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	var rules []CertRule
+	if err := db.Where("(sling_type = 0 OR sling_type = ?) AND min_tonnage <= ?", sling.SlingType, sling.MaxTonnage).
+		Find(&rules).Error; err != nil {
+		return err
+	}
+	// 按借出时间检查，补传的离线借出不受之后证书过期的影响
+	now := time.Now()
+	if useLog.TakeTime != nil {
+		now = time.Time(*useLog.TakeTime)
+	}
+	for _, rule := range rules {
+		var count int64
+		if err := db.Model(&StaffCert{}).
+			Where("staff_id = ? AND cert_type = ? AND level >= ?", useLog.TakeStaffID, rule.CertType, rule.MinLevel).
+			Where("issue_date IS NULL OR issue_date <= ?", now).
+			Where("expire_date IS NULL OR expire_date >= ?", now).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return common.ErrStaffNotQualified
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package logic
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+const (
+	// TopicCabinetCommand 发送给智能柜的指令
+	TopicCabinetCommand = "cabinet.command"
+	// TopicCabinetDoor 智能柜柜门开关
+	TopicCabinetDoor = "cabinet.door"
+	// TopicCabinetHeartbeat 智能柜心跳
+	TopicCabinetHeartbeat = "cabinet.heartbeat"
+)
+
+// 智能柜上报的事件类型
+const (
+	// DeviceDoorOpened 柜门打开
+	DeviceDoorOpened = "door_opened"
+	// DeviceDoorClosed 柜门关闭
+	DeviceDoorClosed = "door_closed"
+	// DeviceTagDetected 箱格中识别到RFID标签
+	DeviceTagDetected = "tag_detected"
+	// DeviceTake 取出吊索具
+	DeviceTake = "take"
+	// DeviceReturn 放回吊索具
+	DeviceReturn = "return"
+	// DeviceHeartbeat 心跳
+	DeviceHeartbeat = "heartbeat"
+	// DeviceCommandAck 指令执行结果
+	DeviceCommandAck = "command_ack"
+)
+
+// 发送给智能柜的指令
+const (
+	// CommandOpenDoor 打开箱格柜门
+	CommandOpenDoor = "open_door"
+	// CommandLockGrid 锁定箱格
+	CommandLockGrid = "lock_grid"
+	// CommandUnlockGrid 解锁箱格
+	CommandUnlockGrid = "unlock_grid"
+	// CommandSync 重新读取配置并上报箱格状态
+	CommandSync = "sync"
+	// CommandReboot 重启
+	CommandReboot = "reboot"
+)
+
+// DeviceEvent 智能柜上报的事件
+type DeviceEvent struct {
+	ID      string    `json:"id"`      // 设备生成的消息ID，用于去重
+	Type    string    `json:"type"`    // 事件类型
+	GridNo  uint      `json:"gridNo"`  // 箱格
+	RfID    string    `json:"rfId"`    // 识别到的RFID
+	ResID   uint      `json:"resId"`   // 吊索具ID，与RFID二选一
+	StaffID uint      `json:"staffId"` // 刷卡的员工
+	Time    *JSONTime `json:"time"`    // 设备时间，实时事件按服务器时间记录，补传事件按设备时间记录
+	Seq     uint64    `json:"seq"`     // 设备事件序号，从1开始连续递增，用于去重和检测缺失
+	// 指令执行结果，error为空表示成功
+	CommandID uint   `json:"commandId"`
+	Error     string `json:"error"`
+
+	// 心跳事件上报的设备状态
+	CabinetHeartbeat
+}
+
+// DeviceGrid 智能柜配置中的箱格
+type DeviceGrid struct {
+	GridNo     uint   `json:"gridNo"`
+	InResID    uint   `json:"inResId"`
+	InRfID     string `json:"inRfId"`
+	IsOut      uint   `json:"isOut"`
+	SizeClass  uint   `json:"sizeClass"`
+	MaxTonnage uint   `json:"maxTonnage"`
+	Status     int16  `json:"status"`
+}
+
+// CabinetConfig 智能柜配置，设备连接或收到sync指令时读取
+type CabinetConfig struct {
+	CabinetID uint         `json:"cabinetId"`
+	Name      string       `json:"name"`
+	GridCount uint         `json:"gridCount"`
+	Grids     []DeviceGrid `json:"grids"`
+	Time      JSONTime     `json:"time"`
+}
+
+// GetCabinetConfig 智能柜配置
+func (lgc *Logics) GetCabinetConfig(cabinetID uint) (*CabinetConfig, error) {
+	cabinet, err := lgc.QueryCabinetByID(cabinetID)
+	if err != nil {
+		return nil, common.ErrNotFound
+	}
+	config := &CabinetConfig{CabinetID: cabinet.ID, Name: cabinet.Name, GridCount: cabinet.GridCount, Time: JSONTime(time.Now())}
+	if err := lgc.db.Table("t_res_cabinet_grid").
+		Select("t_res_cabinet_grid.grid_no, t_res_cabinet_grid.in_res_id, t_res_sling.rf_id AS in_rf_id, t_res_cabinet_grid.is_out, "+
+			"t_res_cabinet_grid.size_class, t_res_cabinet_grid.max_tonnage, t_res_cabinet_grid.status").
+		Joins("LEFT JOIN t_res_sling ON t_res_sling.id = t_res_cabinet_grid.in_res_id").
+		Where("t_res_cabinet_grid.cabinet_id = ? AND t_res_cabinet_grid.grid_no <= ? AND t_res_cabinet_grid.deleted_at IS NULL",
+			cabinet.ID, cabinet.GridCount).
+		Order("t_res_cabinet_grid.grid_no").Scan(&config.Grids).Error; err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ListCabinetIDs 所有智能柜ID
+func (lgc *Logics) ListCabinetIDs() ([]uint, error) {
+	var ids []uint
+	if err := lgc.db.Model(&Cabinet{}).Where("deleted_at IS NULL").Order("id").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// HandleDeviceEvent 处理智能柜上报的事件，取还对应借还操作，识别到未入柜的吊索具时存入该箱格。
+// 按消息ID和序号去重，重复的消息返回首次处理的结果，带序号的事件记录到事件日志中
+func (lgc *Logics) HandleDeviceEvent(cabinetID uint, e *DeviceEvent) error {
+	cabinet, err := lgc.QueryCabinetByID(cabinetID)
+	if err != nil {
+		return common.ErrNotFound
+	}
+	outcome, err := lgc.processDeviceEvent(cabinet, e, lgc.applyDeviceEvent)
+	if err != nil {
+		return err
+	}
+	return outcome.err
+}
+
+// beforeDeviceEvent 取还事件在事务前处理过期的预约
+func (lgc *Logics) beforeDeviceEvent(e *DeviceEvent) error {
+	if e.Type != DeviceTake && e.Type != DeviceReturn {
+		return nil
+	}
+	return lgc.ExpireReservations()
+}
+
+// applyDeviceEvent 按实时事件处理，借还时间为服务器时间。
+// 借还在事务tx中记录；状态、指令结果和入柜由各自的事务处理，重复处理结果相同
+func (lgc *Logics) applyDeviceEvent(tx *gorm.DB, cabinet *Cabinet, e *DeviceEvent) (string, []*ResEvent, error) {
+	switch e.Type {
+	case DeviceHeartbeat:
+		if err := lgc.RecordHeartbeat(cabinet.ID, &e.CabinetHeartbeat); err != nil {
+			return "", nil, err
+		}
+		lgc.bus.Publish(TopicCabinetHeartbeat, map[string]interface{}{"cabinetId": cabinet.ID, "event": e})
+		return "", nil, nil
+	case DeviceDoorOpened, DeviceDoorClosed:
+		lgc.bus.Publish(TopicCabinetDoor, map[string]interface{}{"cabinetId": cabinet.ID, "event": e})
+		return "", nil, nil
+	case DeviceCommandAck:
+		return "", nil, lgc.AckCabinetCommand(cabinet.ID, e.CommandID, e.Error)
+	}
+
+	sling, err := lgc.deviceEventSling(cabinet.ID, e)
+	if err != nil {
+		return "", nil, err
+	}
+	e.ResID = sling.ID
+	switch e.Type {
+	case DeviceTagDetected:
+		// 已在箱格中的吊索具由取还事件处理
+		var count int64
+		if err := lgc.db.Model(&CabinetGrid{}).Where("in_res_id = ?", sling.ID).Count(&count).Error; err != nil {
+			return "", nil, err
+		}
+		if count > 0 {
+			return "", nil, nil
+		}
+		return "", nil, lgc.Store(cabinet.ID, e.GridNo, sling.ID)
+	case DeviceTake, DeviceReturn:
+		useLog, err := lgc.deviceUseLog(cabinet, e, sling)
+		if err != nil {
+			return "", nil, err
+		}
+		event, err := takeReturnInTx(tx, useLog)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", []*ResEvent{event}, nil
+	}
+	return "", nil, common.ErrBadQueryParams
+}
+
+// deviceUseLog 取还事件对应的借还记录
+func (lgc *Logics) deviceUseLog(cabinet *Cabinet, e *DeviceEvent, sling *Sling) (*UseLog, error) {
+	useLog := &UseLog{ResID: sling.ID, RfID: sling.RfID, ResName: sling.Name}
+	var staff Staff
+	if e.StaffID > 0 {
+		if err := lgc.db.Where("id = ?", e.StaffID).First(&staff).Error; err != nil {
+			return nil, common.ErrNotFound
+		}
+	}
+	if e.Type == DeviceTake {
+		useLog.Flag = 1
+		useLog.TakeStaffID, useLog.TakeStaffName = staff.ID, staff.Name
+	} else {
+		useLog.ReturnStaffID, useLog.ReturnStaffName = staff.ID, staff.Name
+		useLog.ReturnCabinetID, useLog.ReturnGridNo = cabinet.ID, e.GridNo
+	}
+	return useLog, nil
+}
+
+// deviceEventSling 事件对应的吊索具，依次按ID、RFID、箱格中存放的吊索具查找
+func (lgc *Logics) deviceEventSling(cabinetID uint, e *DeviceEvent) (*Sling, error) {
+	var sling Sling
+	slingdb := lgc.db.Where("deleted_at IS NULL")
+	switch {
+	case e.ResID > 0:
+		slingdb = slingdb.Where("id = ?", e.ResID)
+	case e.RfID != "":
+		slingdb = slingdb.Where("rf_id = ?", e.RfID)
+	case e.GridNo > 0:
+		slingdb = slingdb.Where("id = (SELECT in_res_id FROM t_res_cabinet_grid WHERE cabinet_id = ? AND grid_no = ?)", cabinetID, e.GridNo)
+	default:
+		return nil, common.ErrBadQueryParams
+	}
+	if err := slingdb.First(&sling).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	return &sling, nil
+}
@@ -0,0 +1,27 @@
+package logic
+
+import "testing"
+
+func TestDeviceUseLog(t *testing.T) {
+	lgc := &Logics{}
+	cabinet := &Cabinet{}
+	cabinet.ID = 3
+	sling := &Sling{RfID: "rf5", Name: "吊带"}
+	sling.ID = 5
+
+	take, err := lgc.deviceUseLog(cabinet, &DeviceEvent{Type: DeviceTake, GridNo: 2}, sling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if take.Flag != 1 || take.ResID != 5 || take.RfID != "rf5" || take.ResName != "吊带" || take.ReturnCabinetID != 0 {
+		t.Errorf("take = %+v", take)
+	}
+
+	ret, err := lgc.deviceUseLog(cabinet, &DeviceEvent{Type: DeviceReturn, GridNo: 2}, sling)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Flag != 0 || ret.ResID != 5 || ret.ReturnCabinetID != 3 || ret.ReturnGridNo != 2 {
+		t.Errorf("return = %+v", ret)
+	}
+}
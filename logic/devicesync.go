@@ -0,0 +1,397 @@
+package logic
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"zone.com/common"
+)
+
+// DeviceEventLog 带序号的设备事件记录，用于去重、检测缺失的序号和查看被拒绝的事件
+type DeviceEventLog struct {
+	BaseModel
+	CabinetID uint      `json:"cabinetId" gorm:"uniqueIndex:idx_device_event_seq"`
+	Seq       uint64    `json:"seq" gorm:"uniqueIndex:idx_device_event_seq"`
+	Type      string    `json:"type" gorm:"size:32"`
+	EventTime *JSONTime `json:"eventTime" gorm:"type:timestamp"` // 设备时间
+	ResID     uint      `json:"resId"`
+	RfID      string    `json:"rfId" gorm:"size:64"`
+	GridNo    uint      `json:"gridNo"`
+	StaffID   uint      `json:"staffId"`
+	Status    int16     `json:"status"`  // 状态：0-已处理，1-冲突已处理，2-已拒绝
+	Result    string    `json:"result"`  // 冲突处理说明或拒绝原因
+	Payload   string    `json:"payload"` // 设备上报的原始事件
+}
+
+// TableName DeviceEventLog
+func (DeviceEventLog) TableName() string {
+	return "t_res_device_event"
+}
+
+// DeviceMessage 已处理的设备消息ID及处理结果。MQTT QoS 1可能重复投递到其他副本，重复的消息返回首次处理的结果
+type DeviceMessage struct {
+	BaseModel
+	CabinetID uint   `json:"cabinetId" gorm:"uniqueIndex:idx_device_message"`
+	MessageID string `json:"messageId" gorm:"size:64;uniqueIndex:idx_device_message"`
+	Code      int    `json:"code"`    // 处理结果代码，与接口返回的代码一致
+	Message   string `json:"message"` // 失败原因
+}
+
+// TableName DeviceMessage
+func (DeviceMessage) TableName() string {
+	return "t_res_device_message"
+}
+
+// deviceMessageRetention 已处理消息ID的保留时间
+const deviceMessageRetention = 7 * 24 * time.Hour
+
+// deviceResult 处理结果对应的代码和失败原因
+func deviceResult(err error) (int, string) {
+	if err == nil {
+		return common.SUCCESS, ""
+	}
+	if he, ok := err.(*common.HttpError); ok {
+		return he.Code, he.Message
+	}
+	return common.ERR_BAD_REQUEST, err.Error()
+}
+
+// result 首次处理的结果
+func (m *DeviceMessage) result() error {
+	if m.Code == common.SUCCESS {
+		return nil
+	}
+	return common.NewHTTPError(m.Code, m.Message)
+}
+
+// CleanDeviceMessages 删除超过保留时间的消息ID，由主节点定时执行
+func (lgc *Logics) CleanDeviceMessages() error {
+	return lgc.db.Where("created_at < ?", time.Now().Add(-deviceMessageRetention)).Delete(&DeviceMessage{}).Error
+}
+
+const (
+	// DeviceEventApplied 已处理
+	DeviceEventApplied int16 = 0
+	// DeviceEventResolved 与服务器记录冲突，已按规则处理
+	DeviceEventResolved int16 = 1
+	// DeviceEventRejected 已拒绝
+	DeviceEventRejected int16 = 2
+)
+
+const (
+	// deviceSyncLockClass 设备事件咨询锁，同一智能柜的事件在多副本间串行处理
+	deviceSyncLockClass int32 = 0x5a44
+	// maxDeviceSyncBatch 一次补传的最大事件数
+	maxDeviceSyncBatch = 500
+	// maxDeviceClockSkew 允许设备时间比服务器时间快的范围
+	maxDeviceClockSkew = 5 * time.Minute
+)
+
+// SeqRange 缺失的序号范围
+type SeqRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// DeviceSyncBatch 补传的事件
+type DeviceSyncBatch struct {
+	Events []DeviceEvent `json:"events"`
+}
+
+// DeviceSyncResult 补传结果
+type DeviceSyncResult struct {
+	Applied   int              `json:"applied"`   // 已处理
+	Resolved  int              `json:"resolved"`  // 冲突已处理
+	Duplicate int              `json:"duplicate"` // 序号已处理过，忽略
+	Rejected  []DeviceEventLog `json:"rejected"`  // 被拒绝的事件及原因
+	LastSeq   uint64           `json:"lastSeq"`   // 已收到的最大序号
+	Gaps      []SeqRange       `json:"gaps"`      // 缺失的序号，设备应重新补传
+}
+
+// deviceEventApply 在事务中处理一条事件，返回冲突处理说明和提交后发布的借还事件
+type deviceEventApply func(tx *gorm.DB, cabinet *Cabinet, e *DeviceEvent) (string, []*ResEvent, error)
+
+// SyncDeviceEvents 智能柜离线期间的事件补传，按序号顺序以设备时间处理。
+// 借出按离线放行记录，调用方须已验证该智能柜的设备令牌
+func (lgc *Logics) SyncDeviceEvents(cabinetID uint, events []DeviceEvent) (*DeviceSyncResult, error) {
+	cabinet, err := lgc.QueryCabinetByID(cabinetID)
+	if err != nil {
+		return nil, common.ErrNotFound
+	}
+	if len(events) > maxDeviceSyncBatch {
+		return nil, common.ErrBadQueryParams
+	}
+	for _, v := range events {
+		if v.Seq == 0 {
+			return nil, common.ErrBadQueryParams
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+	result, err := lgc.journalDeviceEvents(cabinet, events, lgc.syncDeviceEvent)
+	if err != nil {
+		return nil, err
+	}
+	if result.LastSeq, result.Gaps, err = lgc.deviceSeqGaps(cabinet.ID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// journalDeviceEvents 依次处理事件并记录，已记录的序号和消息ID不再处理
+func (lgc *Logics) journalDeviceEvents(cabinet *Cabinet, events []DeviceEvent, apply deviceEventApply) (*DeviceSyncResult, error) {
+	result := &DeviceSyncResult{Rejected: []DeviceEventLog{}}
+	for i := range events {
+		outcome, err := lgc.processDeviceEvent(cabinet, &events[i], apply)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case outcome.duplicate:
+			result.Duplicate++
+		case outcome.log.Status == DeviceEventApplied:
+			result.Applied++
+		case outcome.log.Status == DeviceEventResolved:
+			result.Resolved++
+		default:
+			result.Rejected = append(result.Rejected, *outcome.log)
+		}
+	}
+	return result, nil
+}
+
+// deviceEventOutcome 一条事件的处理情况
+type deviceEventOutcome struct {
+	log       *DeviceEventLog // 带序号事件的记录
+	duplicate bool            // 消息ID或序号已处理过
+	err       error           // 处理失败的原因，重复的消息为首次处理的结果
+}
+
+// processDeviceEvent 在同一事务中处理事件并记录消息ID和序号，按唯一索引去重。
+// 处理失败时回滚到记录之后，保存失败原因；中断后重传时处理和记录都未提交，会重新处理
+func (lgc *Logics) processDeviceEvent(cabinet *Cabinet, e *DeviceEvent, apply deviceEventApply) (*deviceEventOutcome, error) {
+	if err := lgc.beforeDeviceEvent(e); err != nil {
+		return nil, err
+	}
+	outcome := &deviceEventOutcome{}
+	var done []*ResEvent
+	err := lgc.db.Transaction(func(tx *gorm.DB) error {
+		// 同一智能柜的事件在多副本间按顺序处理
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", deviceSyncLockClass, cabinet.ID).Error; err != nil {
+			return err
+		}
+		var msg *DeviceMessage
+		if e.ID != "" {
+			msg = &DeviceMessage{CabinetID: cabinet.ID, MessageID: e.ID}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(msg)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				var first DeviceMessage
+				if err := tx.Where("cabinet_id = ? AND message_id = ?", cabinet.ID, e.ID).First(&first).Error; err != nil {
+					return err
+				}
+				outcome.duplicate, outcome.err = true, first.result()
+				return nil
+			}
+		}
+		if e.Seq > 0 {
+			payload, _ := json.Marshal(e)
+			outcome.log = &DeviceEventLog{CabinetID: cabinet.ID, Seq: e.Seq, Type: e.Type, EventTime: e.Time,
+				ResID: e.ResID, RfID: e.RfID, GridNo: e.GridNo, StaffID: e.StaffID, Payload: string(payload)}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(outcome.log)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				// 序号已处理过，新的消息ID按成功记录
+				outcome.log, outcome.duplicate = nil, true
+				return saveDeviceMessage(tx, msg, nil)
+			}
+		}
+
+		if err := tx.SavePoint("device_event").Error; err != nil {
+			return err
+		}
+		note, events, err := apply(tx, cabinet, e)
+		if err != nil {
+			if err := tx.RollbackTo("device_event").Error; err != nil {
+				return err
+			}
+			outcome.err = err
+		} else {
+			done = events
+		}
+		if log := outcome.log; log != nil {
+			switch {
+			case err != nil:
+				log.Status, log.Result = DeviceEventRejected, err.Error()
+			case note != "":
+				log.Status, log.Result = DeviceEventResolved, note
+			}
+			log.ResID = e.ResID
+			if err := tx.Model(log).Updates(map[string]interface{}{"res_id": log.ResID, "status": log.Status,
+				"result": log.Result}).Error; err != nil {
+				return err
+			}
+		}
+		return saveDeviceMessage(tx, msg, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range done {
+		lgc.afterTakeReturn(v)
+	}
+	return outcome, nil
+}
+
+// saveDeviceMessage 保存消息的处理结果，msg为nil时不处理
+func saveDeviceMessage(tx *gorm.DB, msg *DeviceMessage, err error) error {
+	if msg == nil {
+		return nil
+	}
+	msg.Code, msg.Message = deviceResult(err)
+	return tx.Model(msg).Updates(map[string]interface{}{"code": msg.Code, "message": msg.Message}).Error
+}
+
+// syncDeviceEvent 处理补传的事件。状态类事件已过时，只记录不处理
+func (lgc *Logics) syncDeviceEvent(tx *gorm.DB, cabinet *Cabinet, e *DeviceEvent) (string, []*ResEvent, error) {
+	if e.Time == nil || time.Time(*e.Time).After(time.Now().Add(maxDeviceClockSkew)) {
+		return "", nil, common.ErrDeviceEventTime
+	}
+	switch e.Type {
+	case DeviceHeartbeat, DeviceDoorOpened, DeviceDoorClosed:
+		return "", nil, nil
+	case DeviceTake, DeviceReturn:
+		return lgc.syncTakeReturn(tx, cabinet, e)
+	}
+	return lgc.applyDeviceEvent(tx, cabinet, e)
+}
+
+// syncTakeReturn 按设备时间补记借还。服务器缺少对应的借出或归还时先补记，早于服务器最近借还记录的事件拒绝
+func (lgc *Logics) syncTakeReturn(tx *gorm.DB, cabinet *Cabinet, e *DeviceEvent) (string, []*ResEvent, error) {
+	sling, err := lgc.deviceEventSling(cabinet.ID, e)
+	if err != nil {
+		return "", nil, err
+	}
+	e.ResID = sling.ID
+	useLog, err := lgc.deviceUseLog(cabinet, e, sling)
+	if err != nil {
+		return "", nil, err
+	}
+	var last UseLog
+	if err := tx.Where("res_id = ? AND deleted_at IS NULL", sling.ID).Order("created_at DESC").
+		Limit(1).Find(&last).Error; err != nil {
+		return "", nil, err
+	}
+	t := time.Time(*e.Time)
+	if (last.TakeTime != nil && t.Before(time.Time(*last.TakeTime))) ||
+		(last.ReturnTime != nil && t.Before(time.Time(*last.ReturnTime))) {
+		return "", nil, common.ErrDeviceEventStale
+	}
+
+	out := sling.UseStatus == 2
+	note := ""
+	var done []*ResEvent
+	if useLog.Flag == 1 {
+		useLog.TakeTime, useLog.Offline = e.Time, true
+		if out {
+			// 服务器未收到上次归还，按本次借出时间补记归还到原箱格
+			missing := &UseLog{ResID: sling.ID, RfID: sling.RfID, ResName: sling.Name, ReturnTime: e.Time,
+				Remark: "[离线补传：未收到归还记录，按再次借出时间补记]"}
+			event, err := takeReturnInTx(tx, missing)
+			if err != nil {
+				return "", nil, err
+			}
+			done = append(done, event)
+			note = "未收到上次归还，已按本次借出时间补记归还"
+		}
+	} else {
+		useLog.ReturnTime = e.Time
+		if !out {
+			// 服务器未收到借出，按归还时间补记借出
+			missing := &UseLog{Flag: 1, ResID: sling.ID, RfID: sling.RfID, ResName: sling.Name,
+				TakeStaffID: useLog.ReturnStaffID, TakeStaffName: useLog.ReturnStaffName, TakeTime: e.Time, Offline: true,
+				Remark: "[离线补传：未收到借出记录，按归还时间补记]"}
+			event, err := takeReturnInTx(tx, missing)
+			if err != nil {
+				return "", nil, err
+			}
+			done = append(done, event)
+			note = "未收到借出记录，已按归还时间补记借出"
+		}
+	}
+	event, err := takeReturnInTx(tx, useLog)
+	if err != nil {
+		return "", nil, err
+	}
+	return note, append(done, event), nil
+}
+
+// deviceSeqGaps 已收到的最大序号和缺失的序号范围
+func (lgc *Logics) deviceSeqGaps(cabinetID uint) (uint64, []SeqRange, error) {
+	var bounds struct {
+		MinSeq uint64
+		MaxSeq uint64
+	}
+	if err := lgc.db.Model(&DeviceEventLog{}).Select("COALESCE(MIN(seq), 0) AS min_seq, COALESCE(MAX(seq), 0) AS max_seq").
+		Where("cabinet_id = ?", cabinetID).Scan(&bounds).Error; err != nil {
+		return 0, nil, err
+	}
+	gaps := []SeqRange{}
+	if bounds.MinSeq > 1 {
+		gaps = append(gaps, SeqRange{From: 1, To: bounds.MinSeq - 1})
+	}
+	var ranges []SeqRange
+	if err := lgc.db.Raw("SELECT seq + 1 AS \"from\", next_seq - 1 AS \"to\" FROM "+
+		"(SELECT seq, LEAD(seq) OVER (ORDER BY seq) AS next_seq FROM t_res_device_event WHERE cabinet_id = ?) t "+
+		"WHERE next_seq > seq + 1 ORDER BY seq", cabinetID).Scan(&ranges).Error; err != nil {
+		return 0, nil, err
+	}
+	return bounds.MaxSeq, append(gaps, ranges...), nil
+}
+
+// DeviceSyncReport 智能柜事件补传情况
+type DeviceSyncReport struct {
+	LastSeq uint64        `json:"lastSeq"`
+	Gaps    []SeqRange    `json:"gaps"`
+	Events  *SearchResult `json:"events"`
+}
+
+// GetDeviceSyncReport 缺失的序号和按状态查询的事件记录，status小于0时查询全部状态
+func (lgc *Logics) GetDeviceSyncReport(cabinetID uint, status int, pageIndex int, pageSize int) (*DeviceSyncReport, error) {
+	if _, err := lgc.QueryCabinetByID(cabinetID); err != nil {
+		return nil, common.ErrNotFound
+	}
+	report := &DeviceSyncReport{}
+	var err error
+	if report.LastSeq, report.Gaps, err = lgc.deviceSeqGaps(cabinetID); err != nil {
+		return nil, err
+	}
+
+	logdb := lgc.db.Model(&DeviceEventLog{}).Where("cabinet_id = ? AND deleted_at IS NULL", cabinetID)
+	if status >= 0 {
+		logdb = logdb.Where("status = ?", status)
+	}
+	if pageIndex == 0 {
+		pageIndex = 1
+	}
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	var rowCount int64
+	logdb.Count(&rowCount)                                             //总行数
+	pageCount := int(math.Ceil(float64(rowCount) / float64(pageSize))) // 总页数
+
+	var logs []DeviceEventLog
+	if err := logdb.Order("seq DESC").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	report.Events = &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &logs}
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TopicSlingTake 吊索具借出
+	TopicSlingTake = "sling.take"
+	// TopicSlingReturn 吊索具归还
+	TopicSlingReturn = "sling.return"
+	// TopicGridChanged 箱格存放或设置变更
+	TopicGridChanged = "grid.changed"
+	// TopicLoanOverdue 借用逾期
+	TopicLoanOverdue = "loan.overdue"
+	// TopicCabinetOffline 智能柜离线
+	TopicCabinetOffline = "cabinet.offline"
+	// TopicCabinetStatus 智能柜在线状态变化
+	TopicCabinetStatus = "cabinet.status"
+	// TopicStats 首页统计数据
+	TopicStats = "stats"
+)
+
+// BusEvent 事件总线消息
+type BusEvent struct {
+	Topic string      `json:"topic"`
+	Time  JSONTime    `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// EventBus 进程内事件总线，logic在事务提交后发布，订阅者缓冲区满时丢弃消息，不阻塞发布方
+type EventBus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]*busSubscriber
+}
+
+type busSubscriber struct {
+	topics []string
+	ch     chan BusEvent
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[int]*busSubscriber{}}
+}
+
+// Subscribe 订阅主题，主题为空时订阅全部，"sling"可匹配"sling.take"等子主题。返回取消订阅的函数
+func (b *EventBus) Subscribe(topics []string) (<-chan BusEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	sub := &busSubscriber{topics: topics, ch: make(chan BusEvent, 64)}
+	b.subs[id] = sub
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(sub.ch)
+		}
+	}
+}
+
+// Publish 发布消息
+func (b *EventBus) Publish(topic string, data interface{}) {
+	event := BusEvent{Topic: topic, Time: JSONTime(time.Now()), Data: data}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs {
+		if !sub.match(topic) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// HasSubscribers 是否有订阅者订阅该主题
+func (b *EventBus) HasSubscribers(topic string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs {
+		if sub.match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *busSubscriber) match(topic string) bool {
+	if len(s.topics) == 0 {
+		return true
+	}
+	for _, v := range s.topics {
+		if v == topic || strings.HasPrefix(topic, v+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,363 @@
+package logic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+// FileObject 上传的文件，内容按SHA-256存储，相同内容只保存一份
+type FileObject struct {
+	BaseModel
+	Hash       string `json:"hash" gorm:"size:64;index"` // 内容SHA-256
+	Size       int64  `json:"size"`                      // 字节数
+	MimeType   string `json:"mimeType" gorm:"size:128"`  // 按内容识别的类型
+	Name       string `json:"name" gorm:"size:255"`      // 原始文件名
+	OwnerID    uint   `json:"ownerId"`                   // 上传用户
+	EntityType string `json:"entityType" gorm:"size:32"` // 关联对象类型
+	EntityID   uint   `json:"entityId"`                  // 关联对象ID
+	Category   string `json:"category" gorm:"size:32"`   // 附件分类：photo-照片，certificate-合格证，inspection_report-检测报告
+}
+
+// TableName FileObject
+func (FileObject) TableName() string {
+	return "t_sys_file"
+}
+
+const (
+	// FileEntityStaff 员工照片
+	FileEntityStaff = "staff"
+	// FileEntityStaffCert 员工证书
+	FileEntityStaffCert = "staff_cert"
+	// FileEntitySling 吊索具
+	FileEntitySling = "sling"
+	// FileEntityCabinet 智能柜
+	FileEntityCabinet = "cabinet"
+	// FileEntityInspection 点检照片
+	FileEntityInspection = "inspection"
+)
+
+var fileEntities = map[string]bool{
+	FileEntityStaff:      true,
+	FileEntityStaffCert:  true,
+	FileEntitySling:      true,
+	FileEntityCabinet:    true,
+	FileEntityInspection: true,
+}
+
+// blobKey 文件内容在存储中的位置，按hash分两级目录存放，避免单个目录文件过多
+func blobKey(hash string) string {
+	return path.Join("blobs", hash[:2], hash[2:4], hash)
+}
+
+// SanitizeFileName 去掉路径和控制字符，限制长度
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = filepath.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(strings.Trim(name, "."))
+	if runes := []rune(name); len(runes) > 128 {
+		ext := filepath.Ext(name)
+		name = string(runes[:128-len([]rune(ext))]) + ext
+	}
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
+
+// SaveFile 按用途的上传策略检查并保存上传的文件，超过大小限制时立即停止读取，内容已存在时只增加记录
+func (lgc *Logics) SaveFile(r io.Reader, name string, ownerID uint, entityType string, entityID uint, category string) (*FileObject, error) {
+	if err := checkAttachment(lgc.db, entityType, entityID, category); err != nil {
+		return nil, err
+	}
+	policy := GetUploadPolicy(entityType)
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// 按内容识别类型，不使用扩展名
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	mimeType := http.DetectContentType(head)
+	if err := policy.checkType(mimeType); err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, io.LimitReader(br, policy.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > policy.MaxSize {
+		return nil, common.ErrFileTooLarge
+	}
+
+	var content io.ReadSeeker = tmp
+	if strings.HasPrefix(mimeType, "image/") {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := policy.checkImage(tmp); err != nil {
+			return nil, err
+		}
+		if policy.StripMeta {
+			data, err := os.ReadFile(tmp.Name())
+			if err != nil {
+				return nil, err
+			}
+			if data, err = stripImageMeta(data, mimeType); err != nil {
+				return nil, err
+			}
+			content, size = bytes.NewReader(data), int64(len(data))
+		}
+	}
+
+	// 计算hash
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return nil, err
+	}
+	file := &FileObject{
+		Hash:       hex.EncodeToString(hash.Sum(nil)),
+		Size:       size,
+		MimeType:   mimeType,
+		Name:       SanitizeFileName(name),
+		OwnerID:    ownerID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Category:   category,
+	}
+	ctx := context.Background()
+	key := blobKey(file.Hash)
+	exists, err := lgc.store.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := lgc.store.Put(ctx, key, content, size, file.MimeType); err != nil {
+			return nil, err
+		}
+	}
+	if err := lgc.db.Create(file).Error; err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// QueryFileByID 查询文件
+func (lgc *Logics) QueryFileByID(id uint) (*FileObject, error) {
+	var file FileObject
+	if err := lgc.db.Where("id = ? AND deleted_at IS NULL", id).First(&file).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	return &file, nil
+}
+
+// FileContent 可下载的文件或缩略图内容
+type FileContent struct {
+	key      string
+	ETag     string
+	MimeType string
+	Name     string
+	ModTime  time.Time
+}
+
+// GetFileContent 检查下载权限后取文件内容，thumb大于0时取该尺寸的缩略图
+func (lgc *Logics) GetFileContent(ctx context.Context, id uint, userID uint, thumb int) (*FileContent, error) {
+	file, err := lgc.QueryFileByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := lgc.checkFileAccess(file, userID); err != nil {
+		return nil, err
+	}
+	return lgc.fileContent(ctx, file, thumb)
+}
+
+// GetSignedFileContent 通过签名地址下载。签名地址只在有下载权限的查询结果中生成，不再检查用户
+func (lgc *Logics) GetSignedFileContent(ctx context.Context, id uint, expires int64, sig string, thumb int) (*FileContent, error) {
+	if !checkFileSignature(id, expires, sig) {
+		return nil, common.ErrNoPermission
+	}
+	file, err := lgc.QueryFileByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return lgc.fileContent(ctx, file, thumb)
+}
+
+// fileContent 文件或缩略图的内容，生成缩略图时ctx结束则停止等待
+func (lgc *Logics) fileContent(ctx context.Context, file *FileObject, thumb int) (*FileContent, error) {
+	var err error
+	content := &FileContent{
+		key:      blobKey(file.Hash),
+		ETag:     fmt.Sprintf(`"%s"`, file.Hash),
+		MimeType: file.MimeType,
+		Name:     file.Name,
+		ModTime:  time.Time(file.CreatedAt),
+	}
+	if thumb > 0 {
+		if content.key, err = lgc.thumbnail(ctx, file, thumb); err != nil {
+			return nil, err
+		}
+		content.ETag = fmt.Sprintf(`"%s-%d"`, file.Hash, thumb)
+		content.MimeType = "image/jpeg"
+		content.Name = strings.TrimSuffix(file.Name, filepath.Ext(file.Name)) + ".jpg"
+	}
+	return content, nil
+}
+
+// OpenFileContent 打开文件内容
+func (lgc *Logics) OpenFileContent(content *FileContent) (io.ReadSeekCloser, error) {
+	return lgc.store.Open(context.Background(), content.key)
+}
+
+// FileContentURL 文件的直接下载地址，存储不支持或未开启预签名时返回空，由服务转发内容
+func (lgc *Logics) FileContentURL(content *FileContent) (string, error) {
+	return lgc.store.URL(context.Background(), content.key, content.Name, content.MimeType)
+}
+
+// checkFileAccess 下载权限：上传用户和管理员可以下载；吊索具、智能柜和点检文件所有用户可以下载；
+// 员工照片和证书只有员工本人和所在部门负责人可以下载；未关联对象的文件只有上传用户可以下载
+func (lgc *Logics) checkFileAccess(file *FileObject, userID uint) error {
+	if file.OwnerID == userID {
+		return nil
+	}
+	return checkEntityAccess(lgc.db, file.EntityType, file.EntityID, userID)
+}
+
+// checkEntityAccess 对象的文件权限：管理员可以操作所有对象；吊索具、智能柜和点检所有用户可以操作；
+// 员工和员工证书只有员工本人和所在部门负责人可以操作
+func checkEntityAccess(db *gorm.DB, entityType string, entityID uint, userID uint) error {
+	admin, err := isAdmin(db, userID)
+	if err != nil {
+		return err
+	}
+	if admin {
+		return nil
+	}
+	staffID := uint(0)
+	switch entityType {
+	case FileEntitySling, FileEntityCabinet, FileEntityInspection:
+		return nil
+	case FileEntityStaff:
+		staffID = entityID
+	case FileEntityStaffCert:
+		var cert StaffCert
+		if err := db.Select("staff_id").Where("id = ?", entityID).First(&cert).Error; err != nil {
+			return common.ErrNoPermission
+		}
+		staffID = cert.StaffID
+	default:
+		return common.ErrNoPermission
+	}
+	var user User
+	if err := db.Where("id = ?", userID).First(&user).Error; err != nil || user.StaffID == 0 {
+		return common.ErrNoPermission
+	}
+	if user.StaffID == staffID {
+		return nil
+	}
+	var count int64
+	if err := db.Model(&Department{}).
+		Where("head_staff_id = ? AND id = (SELECT department_id FROM t_sys_staff WHERE id = ?)", user.StaffID, staffID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return common.ErrNoPermission
+	}
+	return nil
+}
+
+// checkFileOwner 只有上传用户和管理员可以修改文件
+func checkFileOwner(db *gorm.DB, file *FileObject, userID uint) error {
+	if file.OwnerID == userID {
+		return nil
+	}
+	admin, err := isAdmin(db, userID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return common.ErrNoPermission
+	}
+	return nil
+}
+
+// linkFile 文件关联到对象，fileID为0或已关联时不处理。
+// 只有上传用户和管理员可以关联，并按对象的上传策略重新检查文件类型和大小
+func linkFile(db *gorm.DB, fileID uint, entityType string, entityID uint, userID uint) error {
+	if fileID == 0 {
+		return nil
+	}
+	var file FileObject
+	if err := db.Where("id = ? AND deleted_at IS NULL", fileID).First(&file).Error; err != nil {
+		return common.ErrNotFound
+	}
+	if file.EntityType == entityType && file.EntityID == entityID {
+		return nil
+	}
+	if err := checkFileOwner(db, &file, userID); err != nil {
+		return err
+	}
+	policy := GetUploadPolicy(entityType)
+	if err := policy.checkType(file.MimeType); err != nil {
+		return err
+	}
+	if file.Size > policy.MaxSize {
+		return common.ErrFileTooLarge
+	}
+	return db.Model(&file).Updates(map[string]interface{}{"entity_type": entityType, "entity_id": entityID}).Error
+}
+
+// DeleteFile 删除文件记录，没有其他记录引用相同内容时删除内容。只有上传用户和管理员可以删除
+func (lgc *Logics) DeleteFile(id uint, userID uint) error {
+	file, err := lgc.QueryFileByID(id)
+	if err != nil {
+		return err
+	}
+	if err := checkFileOwner(lgc.db, file, userID); err != nil {
+		return err
+	}
+	if err := lgc.db.Where("id = ?", id).Delete(&FileObject{}).Error; err != nil {
+		return err
+	}
+	var count int64
+	if err := lgc.db.Model(&FileObject{}).Where("hash = ?", file.Hash).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := lgc.store.Delete(context.Background(), blobKey(file.Hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,12 @@
 package logic
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"zone.com/common"
+)
 
 // StatAllRes 统计资源数
 func (lgc *Logics) StatAllRes() (*[]map[string]interface{}, error) {
@@ -97,3 +103,214 @@ func (lgc *Logics) StatSlingByStatus() (*[]map[string]interface{}, error) {
 
 	return &result, nil
 }
+
+// UsageTrendParam 借还趋势统计参数
+type UsageTrendParam struct {
+	StartTime string // 开始时间，默认30天前
+	EndTime   string // 结束时间，默认当前
+	Unit      string // 时间粒度：day、week、month
+	GroupBy   string // 分组：type-类型，tonnage-吨位，cabinet-智能柜，department-部门，staff-员工
+}
+
+// UsageTrend 借还趋势统计
+type UsageTrend struct {
+	Period     JSONTime `json:"period"`     // 时间段起始
+	GroupID    uint     `json:"groupId"`    // 分组ID
+	GroupName  string   `json:"groupName"`  // 分组名称
+	Loans      int      `json:"loans"`      // 借出次数
+	Returns    int      `json:"returns"`    // 归还次数
+	Overdue    int      `json:"overdue"`    // 逾期次数
+	AvgMinutes float64  `json:"avgMinutes"` // 平均借用时长（分钟）
+}
+
+// trendGroup 分组字段及关联表，l为借还记录表，s为吊索具表，st为借用员工表
+type trendGroup struct {
+	id    string
+	name  string
+	joins string
+}
+
+var trendGroups = map[string]trendGroup{
+	"":           {"0", "''", ""},
+	"type":       {"s.sling_type", "COALESCE(dt.name, CAST(s.sling_type AS VARCHAR))", "LEFT JOIN t_sys_dict AS dt ON dt.key = s.sling_type AND dt.type = 'SLING_TYPE'"},
+	"tonnage":    {"s.max_tonnage", "COALESCE(dt.name, CAST(s.max_tonnage AS VARCHAR))", "LEFT JOIN t_sys_dict AS dt ON dt.key = s.max_tonnage AND dt.type = 'TON_TYPE'"},
+	"cabinet":    {"l.take_cabinet_id", "COALESCE(c.name, '')", "LEFT JOIN t_res_cabinet AS c ON c.id = l.take_cabinet_id"},
+	"department": {"COALESCE(st.department_id, 0)", "COALESCE(d.name, '')", "LEFT JOIN t_sys_department AS d ON d.id = st.department_id"},
+	"staff":      {"l.take_staff_id", "l.take_staff_name", ""},
+}
+
+var trendUnits = map[string]bool{"day": true, "week": true, "month": true}
+
+// StatUsageTrend 按时间段统计借出、归还、逾期次数及平均借用时长
+// 借出按借用时间、归还按归还时间、逾期按预计归还时间归入时间段，智能柜按借出的智能柜统计
+func (lgc *Logics) StatUsageTrend(param *UsageTrendParam) (*[]UsageTrend, error) {
+	if param.Unit == "" {
+		param.Unit = "day"
+	}
+	group, ok := trendGroups[param.GroupBy]
+	if !ok || !trendUnits[param.Unit] {
+		return nil, common.ErrBadQueryParams
+	}
+	end := time.Now()
+	if param.EndTime != "" {
+		t, err := parseStatTime(param.EndTime, true)
+		if err != nil {
+			return nil, common.ErrBadQueryParams
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -30)
+	if param.StartTime != "" {
+		t, err := parseStatTime(param.StartTime, false)
+		if err != nil {
+			return nil, common.ErrBadQueryParams
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return nil, common.ErrBadQueryParams
+	}
+	from := fmt.Sprintf(`FROM t_res_use_log AS l
+		LEFT JOIN t_res_sling AS s ON s.id = l.res_id
+		LEFT JOIN t_sys_staff AS st ON st.id = l.take_staff_id
+		%s`, group.joins)
+	sql := fmt.Sprintf(`SELECT date_trunc('%[1]s', e.t) AS period, e.group_id, MAX(e.group_name) AS group_name,
+		SUM(e.loans) AS loans, SUM(e.returns) AS returns, SUM(e.overdue) AS overdue, COALESCE(AVG(e.minutes), 0) AS avg_minutes
+	FROM (
+		SELECT COALESCE(l.take_time, l.created_at) AS t, 1 AS loans, 0 AS returns, 0 AS overdue, CAST(NULL AS FLOAT) AS minutes,
+			%[2]s AS group_id, %[3]s AS group_name
+		%[4]s
+		WHERE COALESCE(l.take_time, l.created_at) >= @start AND COALESCE(l.take_time, l.created_at) < @end
+		UNION ALL
+		SELECT l.return_time, 0, 1, 0,
+			CAST(COALESCE(NULLIF(l.use_minutes, 0), EXTRACT(EPOCH FROM l.return_time - COALESCE(l.take_time, l.created_at)) / 60) AS FLOAT),
+			%[2]s, %[3]s
+		%[4]s
+		WHERE l.return_time >= @start AND l.return_time < @end
+		UNION ALL
+		SELECT l.return_plan_time, 0, 0, 1, CAST(NULL AS FLOAT), %[2]s, %[3]s
+		%[4]s
+		WHERE l.return_plan_time >= @start AND l.return_plan_time < @end
+			AND ((l.return_time IS NULL AND l.return_plan_time < @now) OR l.return_time > l.return_plan_time)
+	) e
+	GROUP BY 1, 2
+	ORDER BY 1, 2`, param.Unit, group.id, group.name, from)
+
+	var result []UsageTrend
+	if err := lgc.db.Raw(sql, map[string]interface{}{"start": start, "end": end, "now": time.Now()}).
+		Scan(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// parseStatTime 解析统计时间，只有日期时取次日零点作为结束时间
+func parseStatTime(s string, isEnd bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// LiveStats 首页实时统计，推送给订阅的客户端
+func (lgc *Logics) LiveStats() (map[string]interface{}, error) {
+	allRes, err := lgc.StatAllRes()
+	if err != nil {
+		return nil, err
+	}
+	byStatus, err := lgc.StatSlingByStatus()
+	if err != nil {
+		return nil, err
+	}
+	var loans, overdue int64
+	if err := lgc.db.Model(&UseLog{}).Where("return_time IS NULL").Count(&loans).Error; err != nil {
+		return nil, err
+	}
+	if err := lgc.db.Model(&UseLog{}).Where("return_time IS NULL AND return_plan_time < ?", time.Now()).
+		Count(&overdue).Error; err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"allRes": allRes, "slingByStatus": byStatus, "loans": loans, "overdue": overdue}, nil
+}
+
+// TakeReturnOps 借还操作次数，在借还事务提交后计数
+var TakeReturnOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "zone",
+	Name:      "take_return_total",
+	Help:      "Number of sling take and return operations by cabinet.",
+}, []string{"operation", "cabinet_id"})
+
+// countTakeReturn 借还计数
+func countTakeReturn(event *ResEvent) {
+	TakeReturnOps.WithLabelValues(event.EventType, fmt.Sprint(event.CabinetID)).Inc()
+}
+
+// BusinessMetrics 业务监控指标
+type BusinessMetrics struct {
+	SlingsByUseStatus     map[uint]int64
+	SlingsByInspectStatus map[uint]int64
+	LoansOut              int64
+	LoansOverdue          int64
+	Cabinets              []CabinetFreeGrids
+}
+
+// CabinetFreeGrids 智能柜空闲箱格
+type CabinetFreeGrids struct {
+	CabinetID   uint
+	CabinetName string
+	GridCount   uint
+	Unavailable uint // 已存放或停用的箱格数
+}
+
+// FreeGrids 空闲箱格数
+func (c *CabinetFreeGrids) FreeGrids() uint {
+	if c.Unavailable > c.GridCount {
+		return 0
+	}
+	return c.GridCount - c.Unavailable
+}
+
+// StatBusinessMetrics 统计业务监控指标，供Prometheus采集
+func (lgc *Logics) StatBusinessMetrics() (*BusinessMetrics, error) {
+	result := &BusinessMetrics{SlingsByUseStatus: map[uint]int64{}, SlingsByInspectStatus: map[uint]int64{}}
+	type statusCount struct {
+		Status uint
+		Count  int64
+	}
+	var counts []statusCount
+	if err := lgc.db.Table("t_res_sling").Select("use_status AS status, COUNT(0) AS count").
+		Where("deleted_at IS NULL").Group("use_status").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range counts {
+		result.SlingsByUseStatus[v.Status] = v.Count
+	}
+	counts = nil
+	if err := lgc.db.Table("t_res_sling").Select("inspect_status AS status, COUNT(0) AS count").
+		Where("deleted_at IS NULL").Group("inspect_status").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range counts {
+		result.SlingsByInspectStatus[v.Status] = v.Count
+	}
+	if err := lgc.db.Table("t_res_use_log").
+		Select("COUNT(0) FILTER (WHERE return_time IS NULL), COUNT(0) FILTER (WHERE return_time IS NULL AND return_plan_time < ?)", time.Now()).
+		Row().Scan(&result.LoansOut, &result.LoansOverdue); err != nil {
+		return nil, err
+	}
+	if err := lgc.db.Table("t_res_cabinet AS c").
+		Select("c.id AS cabinet_id, c.name AS cabinet_name, c.grid_count, COUNT(g.id) AS unavailable").
+		Joins("LEFT JOIN t_res_cabinet_grid AS g ON g.cabinet_id = c.id AND g.grid_no <= c.grid_count AND (g.in_res_id > 0 OR g.status <> ?)", GridStatusNormal).
+		Where("c.deleted_at IS NULL").Group("c.id").Order("c.id").
+		Scan(&result.Cabinets).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
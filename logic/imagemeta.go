@@ -0,0 +1,219 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"zone.com/common"
+)
+
+// stripImageMeta 去除JPEG、PNG和WebP中的EXIF、XMP、注释等元数据，其他类型原样返回
+// JPEG按EXIF方向旋转后重新编码，方向正常时不重新编码
+func stripImageMeta(data []byte, mimeType string) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		result, orientation, err := stripJPEGMeta(data)
+		if err != nil {
+			return nil, err
+		}
+		if orientation <= 1 || orientation > 8 {
+			return result, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(result))
+		if err != nil {
+			return nil, common.ErrFileNotImage
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, orientImage(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "image/png":
+		return stripPNGMeta(data)
+	case "image/webp":
+		return stripWebPMeta(data)
+	}
+	return data, nil
+}
+
+// stripJPEGMeta 去除APP1(EXIF/XMP)、APP13(IPTC)和注释段，返回EXIF中的方向
+func stripJPEGMeta(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, common.ErrFileNotImage
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, 0xFF, 0xD8)
+	orientation := 0
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return nil, 0, common.ErrFileNotImage
+		}
+		marker := data[i+1]
+		if marker == 0xFF {
+			// 填充字节
+			i++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// 图像数据开始，其后原样保留
+			return append(result, data[i:]...), orientation, nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			result = append(result, data[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return nil, 0, common.ErrFileNotImage
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, 0, common.ErrFileNotImage
+		}
+		switch marker {
+		case 0xE1:
+			if o := exifOrientation(data[i+4 : end]); o > 0 {
+				orientation = o
+			}
+		case 0xED, 0xFE:
+		default:
+			result = append(result, data[i:end]...)
+		}
+		i = end
+	}
+}
+
+// exifOrientation 读取EXIF中IFD0的方向标签，没有时返回0
+func exifOrientation(exif []byte) int {
+	if len(exif) < 14 || string(exif[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := exif[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// orientTransforms 各EXIF方向从原图坐标到结果坐标的仿射变换，w、h为原图宽高
+var orientTransforms = map[int]func(w, h float64) f64.Aff3{
+	2: func(w, h float64) f64.Aff3 { return f64.Aff3{-1, 0, w, 0, 1, 0} },
+	3: func(w, h float64) f64.Aff3 { return f64.Aff3{-1, 0, w, 0, -1, h} },
+	4: func(w, h float64) f64.Aff3 { return f64.Aff3{1, 0, 0, 0, -1, h} },
+	5: func(w, h float64) f64.Aff3 { return f64.Aff3{0, 1, 0, 1, 0, 0} },
+	6: func(w, h float64) f64.Aff3 { return f64.Aff3{0, -1, h, 1, 0, 0} },
+	7: func(w, h float64) f64.Aff3 { return f64.Aff3{0, -1, h, -1, 0, w} },
+	8: func(w, h float64) f64.Aff3 { return f64.Aff3{0, 1, 0, -1, 0, w} },
+}
+
+// orientImage 按EXIF方向变换图片，直接绘制到结果图片中
+func orientImage(img image.Image, orientation int) image.Image {
+	transform, ok := orientTransforms[orientation]
+	if !ok {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	m := transform(float64(w), float64(h))
+	// 原图坐标从b.Min开始
+	m[2] -= m[0]*float64(b.Min.X) + m[1]*float64(b.Min.Y)
+	m[5] -= m[3]*float64(b.Min.X) + m[4]*float64(b.Min.Y)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.NearestNeighbor.Transform(dst, m, img, b, draw.Src, nil)
+	return dst
+}
+
+// pngMetaChunks PNG中的元数据块
+var pngMetaChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNGMeta 去除PNG中的文字、EXIF和时间块
+func stripPNGMeta(data []byte) ([]byte, error) {
+	if len(data) < 8 || string(data[:8]) != "\x89PNG\r\n\x1a\n" {
+		return nil, common.ErrFileNotImage
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, data[:8]...)
+	i := 8
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, common.ErrFileNotImage
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, common.ErrFileNotImage
+		}
+		if !pngMetaChunks[string(data[i+4:i+8])] {
+			result = append(result, data[i:end]...)
+		}
+		i = end
+	}
+	return result, nil
+}
+
+// stripWebPMeta 去除WebP中的EXIF和XMP块，并清除VP8X中对应的标志
+func stripWebPMeta(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, common.ErrFileNotImage
+	}
+	result := make([]byte, 0, len(data))
+	result = append(result, data[:12]...)
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, common.ErrFileNotImage
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size&1
+		if end == len(data)+1 && size&1 == 1 {
+			// 最后一块缺少填充字节
+			end = len(data)
+		}
+		if end > len(data) || end < i+8 {
+			return nil, common.ErrFileNotImage
+		}
+		switch string(data[i : i+4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, data[i:end]...)
+			if size > 0 {
+				// 第一个字节的第3位为EXIF，第2位为XMP
+				chunk[8] &^= 0x08 | 0x04
+			}
+			result = append(result, chunk...)
+		default:
+			result = append(result, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
@@ -0,0 +1,315 @@
+package logic
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+const (
+	// labelScheme 标签二维码内容的前缀，如 zone://sling?id=1&rfid=xx
+	labelScheme = "zone"
+	// LabelSling 吊索具标签
+	LabelSling = "sling"
+	// LabelGrid 箱格标签
+	LabelGrid = "grid"
+)
+
+// label 一个标签的二维码内容和文字
+type label struct {
+	payload string
+	lines   []string
+}
+
+// ScanResult 扫码结果
+type ScanResult struct {
+	Type  string       `json:"type"` // sling-吊索具，grid-箱格
+	Sling *Sling       `json:"sling,omitempty"`
+	Grid  *CabinetGrid `json:"grid,omitempty"`
+}
+
+// slingPayload 吊索具二维码内容，包含ID、RFID、名称和吨位
+func slingPayload(sling *Sling) string {
+	q := url.Values{}
+	q.Set("id", strconv.Itoa(int(sling.ID)))
+	q.Set("rfid", sling.RfID)
+	q.Set("name", sling.Name)
+	q.Set("ton", strconv.Itoa(int(sling.MaxTonnage)))
+	return fmt.Sprintf("%s://%s?%s", labelScheme, LabelSling, q.Encode())
+}
+
+// gridPayload 箱格二维码内容
+func gridPayload(cabinetID uint, gridNo uint) string {
+	return fmt.Sprintf("%s://%s?cabinet=%d&grid=%d", labelScheme, LabelGrid, cabinetID, gridNo)
+}
+
+// slingLabels 吊索具标签
+func (lgc *Logics) slingLabels(slings []Sling) ([]label, error) {
+	dicts, err := lgc.reportDicts()
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]label, len(slings))
+	for i, v := range slings {
+		labels[i] = label{payload: slingPayload(&v), lines: []string{v.Name, "RFID: " + v.RfID,
+			dicts.name("TON_TYPE", v.MaxTonnage), fmt.Sprintf("ID: %d", v.ID)}}
+	}
+	return labels, nil
+}
+
+// gridLabels 智能柜箱格标签，gridNo为0时生成全部箱格
+func (lgc *Logics) gridLabels(cabinetID uint, gridNo uint) ([]label, error) {
+	cabinet, err := lgc.QueryCabinetByID(cabinetID)
+	if err != nil {
+		return nil, common.ErrNotFound
+	}
+	if gridNo > cabinet.GridCount {
+		return nil, common.ErrGridNotFound
+	}
+	var labels []label
+	for i := uint(1); i <= cabinet.GridCount; i++ {
+		if gridNo > 0 && i != gridNo {
+			continue
+		}
+		labels = append(labels, label{payload: gridPayload(cabinet.ID, i),
+			lines: []string{cabinet.Name, fmt.Sprintf("箱格 %d", i), cabinet.Location}})
+	}
+	return labels, nil
+}
+
+// SlingLabelPNG 单个吊索具的PNG标签
+func (lgc *Logics) SlingLabelPNG(w io.Writer, id uint) error {
+	sling, err := lgc.QuerySlingByID(id)
+	if err != nil {
+		return common.ErrNotFound
+	}
+	labels, err := lgc.slingLabels([]Sling{*sling})
+	if err != nil {
+		return err
+	}
+	return writeLabelPNG(w, &labels[0])
+}
+
+// SlingLabelsPDF 吊索具标签页，ids为空时按查询条件生成
+func (lgc *Logics) SlingLabelsPDF(w io.Writer, ids []uint, param *SlingQueryParam) error {
+	var slings []Sling
+	slingdb := lgc.db.Where("deleted_at IS NULL").Order("id")
+	if len(ids) > 0 {
+		slingdb = slingdb.Where("id IN ?", ids)
+	} else {
+		slingdb = lgc.slingQuery(param).Order("t_res_sling.id")
+	}
+	if err := slingdb.Find(&slings).Error; err != nil {
+		return err
+	}
+	labels, err := lgc.slingLabels(slings)
+	if err != nil {
+		return err
+	}
+	return writeLabelPDF(w, labels)
+}
+
+// GridLabelPNG 单个箱格的PNG标签
+func (lgc *Logics) GridLabelPNG(w io.Writer, cabinetID uint, gridNo uint) error {
+	if gridNo == 0 {
+		return common.ErrGridNotFound
+	}
+	labels, err := lgc.gridLabels(cabinetID, gridNo)
+	if err != nil {
+		return err
+	}
+	return writeLabelPNG(w, &labels[0])
+}
+
+// GridLabelsPDF 智能柜全部箱格的标签页
+func (lgc *Logics) GridLabelsPDF(w io.Writer, cabinetID uint) error {
+	labels, err := lgc.gridLabels(cabinetID, 0)
+	if err != nil {
+		return err
+	}
+	return writeLabelPDF(w, labels)
+}
+
+// ScanLabel 解析二维码内容，不是标签二维码时按RFID查找吊索具，用于RFID标签丢失时借还
+func (lgc *Logics) ScanLabel(payload string) (*ScanResult, error) {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return nil, common.ErrBadQueryParams
+	}
+	u, err := url.Parse(payload)
+	if err != nil || u.Scheme != labelScheme {
+		var sling Sling
+		if err := lgc.db.Where("rf_id = ? AND deleted_at IS NULL", payload).First(&sling).Error; err != nil {
+			return nil, common.ErrNotFound
+		}
+		return &ScanResult{Type: LabelSling, Sling: &sling}, nil
+	}
+	q := u.Query()
+	switch u.Host {
+	case LabelSling:
+		// 按ID查找，吊索具已删除或ID不一致时按RFID查找
+		var sling Sling
+		id, _ := strconv.Atoi(q.Get("id"))
+		if err := lgc.db.Where("id = ? AND deleted_at IS NULL", id).First(&sling).Error; err != nil {
+			if q.Get("rfid") == "" || lgc.db.Where("rf_id = ? AND deleted_at IS NULL", q.Get("rfid")).First(&sling).Error != nil {
+				return nil, common.ErrNotFound
+			}
+		}
+		return &ScanResult{Type: LabelSling, Sling: &sling}, nil
+	case LabelGrid:
+		cabinetID, _ := strconv.Atoi(q.Get("cabinet"))
+		gridNo, _ := strconv.Atoi(q.Get("grid"))
+		var grid CabinetGrid
+		if err := lgc.db.Where("cabinet_id = ? AND grid_no = ? AND deleted_at IS NULL", cabinetID, gridNo).
+			First(&grid).Error; err != nil {
+			return nil, common.ErrGridNotFound
+		}
+		return &ScanResult{Type: LabelGrid, Grid: &grid}, nil
+	}
+	return nil, common.ErrBadQueryParams
+}
+
+// resolveScannedSling 借还时按扫码内容确定吊索具
+func (lgc *Logics) resolveScannedSling(useLog *UseLog) error {
+	if useLog.ResID > 0 || useLog.QRCode == "" {
+		return nil
+	}
+	result, err := lgc.ScanLabel(useLog.QRCode)
+	if err != nil {
+		return err
+	}
+	if result.Sling == nil {
+		return common.ErrBadQueryParams
+	}
+	useLog.ResID = result.Sling.ID
+	return nil
+}
+
+const (
+	labelWidth   = 600
+	labelHeight  = 300
+	labelQRSize  = 280
+	labelPadding = 10
+)
+
+// labelFace 标签文字字体，配置了PDF字体时使用该字体以显示中文
+func labelFace(size float64) (font.Face, error) {
+	if util.PDFFont == "" {
+		return basicfont.Face7x13, nil
+	}
+	data, err := os.ReadFile(util.PDFFont)
+	if err != nil {
+		return nil, err
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// writeLabelPNG 左侧二维码，右侧文字
+func writeLabelPNG(w io.Writer, l *label) error {
+	qr, err := qrcode.New(l.payload, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, labelWidth, labelHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	top := (labelHeight - labelQRSize) / 2
+	draw.Draw(img, image.Rect(labelPadding, top, labelPadding+labelQRSize, top+labelQRSize),
+		qr.Image(labelQRSize), image.Point{}, draw.Src)
+
+	face, err := labelFace(28)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	drawer := &font.Drawer{Dst: img, Src: image.NewUniform(color.Black), Face: face}
+	lineHeight := face.Metrics().Height.Ceil() + 12
+	y := top + labelPadding + face.Metrics().Ascent.Ceil()
+	for _, line := range l.lines {
+		drawer.Dot = fixed.P(labelQRSize+3*labelPadding, y)
+		drawer.DrawString(line)
+		y += lineHeight
+	}
+	return png.Encode(w, img)
+}
+
+// 标签页：A4纵向，3列8行，单位毫米
+const (
+	labelCols       = 3
+	labelRows       = 8
+	labelCellWidth  = 70.0
+	labelCellHeight = 37.0
+	labelCellQR     = 30.0
+)
+
+// writeLabelPDF 标签页，每页24个标签
+func writeLabelPDF(w io.Writer, labels []label) error {
+	if err := CheckReportFormat(ReportPDF); err != nil {
+		return err
+	}
+	fontData, err := os.ReadFile(util.PDFFont)
+	if err != nil {
+		return err
+	}
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddUTF8FontFromBytes(pdfFontName, "", fontData)
+	pdf.SetFont(pdfFontName, "", pdfFontSize)
+	pageWidth, pageHeight := pdf.GetPageSize()
+	left := (pageWidth - labelCols*labelCellWidth) / 2
+	top := (pageHeight - labelRows*labelCellHeight) / 2
+	for i, l := range labels {
+		n := i % (labelCols * labelRows)
+		if n == 0 {
+			pdf.AddPage()
+		}
+		x := left + float64(n%labelCols)*labelCellWidth
+		y := top + float64(n/labelCols)*labelCellHeight
+		pdf.SetDrawColor(200, 200, 200)
+		pdf.Rect(x, y, labelCellWidth, labelCellHeight, "D")
+
+		qr, err := qrcode.Encode(l.payload, qrcode.Medium, 256)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("qr%d", i)
+		pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+		pdf.ImageOptions(name, x+2, y+(labelCellHeight-labelCellQR)/2, labelCellQR, labelCellQR, false, gofpdf.ImageOptions{}, 0, "")
+
+		textX := x + labelCellQR + 4
+		textWidth := labelCellWidth - labelCellQR - 6
+		pdf.SetXY(textX, y+4)
+		for _, line := range l.lines {
+			pdf.SetX(textX)
+			pdf.CellFormat(textWidth, pdfLineHeight, fitText(pdf, line, textWidth), "", 2, "L", false, 0, "")
+		}
+	}
+	if len(labels) == 0 {
+		pdf.AddPage()
+	}
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
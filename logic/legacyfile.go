@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"fmt"
+	"os"
+
+	"zone.com/common"
+	"zone.com/util"
+)
+
+// legacyStaffPhotos 旧版本员工照片的位置，保存员工时从./temp复制到文件目录，按顺序查找
+func legacyStaffPhotos(staffID uint) []string {
+	name := fmt.Sprintf("%06d.jpg", staffID)
+	return []string{util.FileDir + name, "./temp/" + name}
+}
+
+// migrateStaffPhotos 旧版本按员工ID保存的照片导入文件存储并关联到员工。
+// 只处理没有照片的员工，不符合上传策略的照片保留在原位置不导入，可重复执行
+func (lgc *Logics) migrateStaffPhotos() error {
+	var staffs []Staff
+	if err := lgc.db.Select("id").Where("photo_id = 0 AND deleted_at IS NULL").Order("id").Find(&staffs).Error; err != nil {
+		return err
+	}
+	for _, staff := range staffs {
+		for _, name := range legacyStaffPhotos(staff.ID) {
+			imported, err := lgc.importStaffPhoto(staff.ID, name)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			if imported {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// importStaffPhoto 导入一张旧照片，文件不存在或不符合上传策略时返回false
+func (lgc *Logics) importStaffPhoto(staffID uint, name string) (bool, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	file, err := lgc.SaveFile(f, name, 0, FileEntityStaff, staffID, "")
+	switch err {
+	case nil:
+	case common.ErrFileType, common.ErrFileTooLarge, common.ErrFileNotImage, common.ErrImageTooLarge:
+		return false, nil
+	default:
+		return false, err
+	}
+	if err := lgc.db.Model(&Staff{}).Where("id = ? AND photo_id = 0", staffID).Update("photo_id", file.ID).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
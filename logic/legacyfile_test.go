@@ -0,0 +1,51 @@
+package logic
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"zone.com/util"
+)
+
+func TestMigrateStaffPhotos(t *testing.T) {
+	dir := t.TempDir()
+	fileDir, storageDir := filepath.Join(dir, "webfiles")+"/", filepath.Join(dir, "storage")
+	if err := os.MkdirAll(fileDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { util.FileDir = old }(util.FileDir)
+	util.FileDir = fileDir
+	f, err := os.Create(fileDir + "000001.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	db, mock := newMockDB(t)
+	lgc := &Logics{db: db, store: &localStorage{root: storageDir}}
+	// 员工1有旧照片，员工2没有
+	mock.ExpectQuery(`SELECT "id" FROM "t_sys_staff" WHERE photo_id = 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "t_sys_staff"`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "t_sys_file"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(`UPDATE "t_sys_staff" SET "photo_id"=\$1,"updated_at"=\$2 WHERE id = \$3 AND photo_id = 0`).
+		WithArgs(9, sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := lgc.migrateStaffPhotos(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(storageDir, "blobs", "*", "*", "*"))
+	if len(blobs) != 1 {
+		t.Errorf("blobs = %v", blobs)
+	}
+}
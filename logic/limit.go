@@ -0,0 +1,275 @@
+package logic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+// BorrowLimit 借用限额
+type BorrowLimit struct {
+	ID         uint   `json:"id" gorm:"primary_key"`
+	Scope      int16  `json:"scope"`      // 范围：1-员工，2-部门，3-角色
+	TargetID   uint   `json:"targetId"`   // 员工、部门或角色ID
+	MaxLoans   int    `json:"maxLoans"`   // 最多同时借用数量，0-不限
+	MaxTonnage uint   `json:"maxTonnage"` // 最多同时借用总吨位，0-不限
+	MaxHours   int    `json:"maxHours"`   // 最长借用时长（小时），0-不限
+	Remark     string `json:"remark"`     // 说明
+}
+
+// TableName BorrowLimit
+func (BorrowLimit) TableName() string {
+	return "t_res_borrow_limit"
+}
+
+const (
+	// LimitScopeStaff 员工
+	LimitScopeStaff int16 = 1
+	// LimitScopeDepartment 部门
+	LimitScopeDepartment int16 = 2
+	// LimitScopeRole 角色
+	LimitScopeRole int16 = 3
+)
+
+// StaffLoans 员工借用情况
+type StaffLoans struct {
+	StaffID   uint        `json:"staffId"`
+	Limit     BorrowLimit `json:"limit"`     // 生效的限额
+	LoanCount int         `json:"loanCount"` // 借用中数量
+	Tonnage   uint        `json:"tonnage"`   // 借用中总吨位
+	Loans     []UseLog    `json:"loans"`     // 借用中记录
+}
+
+// ListBorrowLimits 查询借用限额
+func (lgc *Logics) ListBorrowLimits(scope int16) (*[]BorrowLimit, error) {
+	limitdb := lgc.db.Model(&BorrowLimit{}).Order("scope, target_id")
+	if scope > 0 {
+		limitdb = limitdb.Where("scope = ?", scope)
+	}
+	var limits []BorrowLimit
+	if err := limitdb.Find(&limits).Error; err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// AddBorrowLimit 添加借用限额
+func (lgc *Logics) AddBorrowLimit(limit *BorrowLimit) error {
+	if err := lgc.checkBorrowLimit(limit); err != nil {
+		return err
+	}
+	if err := lgc.db.Create(&limit).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateBorrowLimit 修改借用限额
+func (lgc *Logics) UpdateBorrowLimit(limit *BorrowLimit) error {
+	if err := lgc.checkBorrowLimit(limit); err != nil {
+		return err
+	}
+	if err := lgc.db.Save(&limit).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteBorrowLimit 删除借用限额
+func (lgc *Logics) DeleteBorrowLimit(id uint) error {
+	if err := lgc.db.Where("id = ?", id).Delete(&BorrowLimit{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (lgc *Logics) checkBorrowLimit(limit *BorrowLimit) error {
+	if limit.Scope < LimitScopeStaff || limit.Scope > LimitScopeRole || limit.TargetID == 0 {
+		return common.ErrBadQueryParams
+	}
+	// 同一对象只能有一条限额
+	var count int64
+	if err := lgc.db.Model(&BorrowLimit{}).
+		Where("scope = ? AND target_id = ? AND id <> ?", limit.Scope, limit.TargetID, limit.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.ErrAlreadyExists
+	}
+	return nil
+}
+
+// GetStaffLoans 查询员工的借用情况
+func (lgc *Logics) GetStaffLoans(staffID uint) (*StaffLoans, error) {
+	limit, err := staffBorrowLimit(lgc.db, staffID)
+	if err != nil {
+		return nil, err
+	}
+	result := &StaffLoans{StaffID: staffID, Limit: *limit}
+	if err := lgc.db.Where("take_staff_id = ? AND return_time IS NULL", staffID).
+		Order("created_at").Find(&result.Loans).Error; err != nil {
+		return nil, err
+	}
+	result.LoanCount = len(result.Loans)
+	if result.Tonnage, err = staffLoanTonnage(lgc.db, staffID); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetUserLoans 查询用户对应员工的借用情况
+func (lgc *Logics) GetUserLoans(userID uint) (*StaffLoans, error) {
+	user, err := lgc.QueryUserByID(userID)
+	if err != nil {
+		return nil, common.ErrUserNotFound
+	}
+	return lgc.GetStaffLoans(user.StaffID)
+}
+
+// staffBorrowLimit 员工生效的限额，每项依次取员工、角色、部门的设置，多个角色取最严格的设置
+func staffBorrowLimit(db *gorm.DB, staffID uint) (*BorrowLimit, error) {
+	// 未知员工不限
+	if staffID == 0 {
+		return &BorrowLimit{}, nil
+	}
+	var staff Staff
+	if err := db.Where("id = ?", staffID).First(&staff).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	var limits []BorrowLimit
+	if err := db.Where("(scope = ? AND target_id = ?) OR (scope = ? AND target_id = ?)",
+		LimitScopeStaff, staffID, LimitScopeDepartment, staff.DepartmentID).
+		Or("scope = ? AND target_id IN (SELECT r_auth_user_role.role_id FROM r_auth_user_role JOIN t_auth_user ON t_auth_user.id = r_auth_user_role.user_id WHERE t_auth_user.staff_id = ? AND t_auth_user.deleted_at IS NULL)",
+			LimitScopeRole, staffID).
+		Find(&limits).Error; err != nil {
+		return nil, err
+	}
+
+	result := &BorrowLimit{}
+	for _, scope := range []int16{LimitScopeStaff, LimitScopeRole, LimitScopeDepartment} {
+		var maxLoans, maxHours int
+		var maxTonnage uint
+		for _, v := range limits {
+			if v.Scope != scope {
+				continue
+			}
+			maxLoans = stricter(maxLoans, v.MaxLoans)
+			maxHours = stricter(maxHours, v.MaxHours)
+			maxTonnage = uint(stricter(int(maxTonnage), int(v.MaxTonnage)))
+		}
+		if result.MaxLoans == 0 {
+			result.MaxLoans = maxLoans
+		}
+		if result.MaxHours == 0 {
+			result.MaxHours = maxHours
+		}
+		if result.MaxTonnage == 0 {
+			result.MaxTonnage = maxTonnage
+		}
+	}
+	return result, nil
+}
+
+// stricter 取较严格的限额，0为不限
+func stricter(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// staffLoanTonnage 员工借用中的吊索具总吨位
+func staffLoanTonnage(db *gorm.DB, staffID uint) (uint, error) {
+	var tonnage uint
+	if err := db.Table("t_res_use_log").
+		Select("COALESCE(SUM(t_res_sling.max_tonnage), 0)").
+		Joins("JOIN t_res_sling ON t_res_sling.id = t_res_use_log.res_id").
+		Where("t_res_use_log.take_staff_id = ? AND t_res_use_log.return_time IS NULL", staffID).
+		Scan(&tonnage).Error; err != nil {
+		return 0, err
+	}
+	return tonnage, nil
+}
+
+// checkStaffLimit 借用限额检查，超出限额时管理员可强制放行并记录在借还记录说明中
+func checkStaffLimit(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	limit, err := staffBorrowLimit(db, useLog.TakeStaffID)
+	if err != nil {
+		return err
+	}
+	// 离线补传的借出按借出时间计算
+	now := time.Now()
+	if useLog.TakeTime != nil {
+		now = time.Time(*useLog.TakeTime)
+	}
+	// 默认预计归还时间
+	if time.Time(useLog.ReturnPlanTime).IsZero() && limit.MaxHours > 0 {
+		useLog.ReturnPlanTime = JSONTime(now.Add(time.Duration(limit.MaxHours) * time.Hour))
+	}
+
+	var exceeded []string
+	if limit.MaxLoans > 0 {
+		var count int64
+		if err := db.Model(&UseLog{}).Where("take_staff_id = ? AND return_time IS NULL", useLog.TakeStaffID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if int(count)+1 > limit.MaxLoans {
+			exceeded = append(exceeded, fmt.Sprintf("借用数量超过%d", limit.MaxLoans))
+		}
+	}
+	if limit.MaxTonnage > 0 {
+		tonnage, err := staffLoanTonnage(db, useLog.TakeStaffID)
+		if err != nil {
+			return err
+		}
+		if tonnage+sling.MaxTonnage > limit.MaxTonnage {
+			exceeded = append(exceeded, fmt.Sprintf("借用总吨位超过%d", limit.MaxTonnage))
+		}
+	}
+	if limit.MaxHours > 0 && time.Time(useLog.ReturnPlanTime).After(now.Add(time.Duration(limit.MaxHours)*time.Hour)) {
+		exceeded = append(exceeded, fmt.Sprintf("借用时长超过%d小时", limit.MaxHours))
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+
+	// 管理员强制放行
+	if !useLog.Override {
+		return common.ErrBorrowLimitExceeded
+	}
+	admin, err := isAdmin(db, useLog.OperatorID)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return common.ErrBorrowLimitExceeded
+	}
+	var operator User
+	db.Where("id = ?", useLog.OperatorID).First(&operator)
+	remark := fmt.Sprintf("[超限放行：%s，操作用户：%s]", strings.Join(exceeded, "，"), operator.Name)
+	if useLog.Remark != "" {
+		remark = useLog.Remark + " " + remark
+	}
+	useLog.Remark = remark
+	return nil
+}
+
+// isAdmin 默认用户或拥有默认角色的用户为管理员
+func isAdmin(db *gorm.DB, userID uint) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+	if userID == 1 {
+		return true, nil
+	}
+	var count int64
+	if err := db.Model(&UserRoleRelation{}).Where("user_id = ? AND role_id = 1", userID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -6,9 +6,16 @@ import (
 
 // Logics framwork need
 type Logics struct {
-	db *gorm.DB
+	db    *gorm.DB
+	bus   *EventBus
+	store Storage
 }
 
 func NewLogics(db *gorm.DB) *Logics {
-	return &Logics{db: db}
+	return &Logics{db: db, bus: NewEventBus()}
+}
+
+// Bus 事件总线
+func (lgc *Logics) Bus() *EventBus {
+	return lgc.bus
 }
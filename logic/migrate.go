@@ -0,0 +1,89 @@
+package logic
+
+// tableColumn 已有数据表中新增的字段
+type tableColumn struct {
+	model interface{}
+	field string
+}
+
+// newTables 新增的数据表
+func newTables() []interface{} {
+	return []interface{}{
+		&Reservation{},
+		&BorrowRequest{},
+		&StaffCert{},
+		&CertRule{},
+		&BorrowLimit{},
+		&ResEvent{},
+		&WearThreshold{},
+		&ReportSubscription{},
+		&FileObject{},
+		&CabinetState{},
+		&CabinetToken{},
+		&CabinetStatusLog{},
+		&CabinetCommand{},
+		&DeviceEventLog{},
+		&DeviceMessage{},
+	}
+}
+
+// newColumns 已有数据表新增的字段
+func newColumns() []tableColumn {
+	return []tableColumn{
+		{&CabinetGrid{}, "SizeClass"},
+		{&CabinetGrid{}, "MaxTonnage"},
+		{&CabinetGrid{}, "Status"},
+		{&CabinetGrid{}, "Remark"},
+		{&Sling{}, "SizeClass"},
+		{&Department{}, "HeadStaffID"},
+		{&Role{}, "Approver"},
+		{&UseLog{}, "TakeCabinetID"},
+		{&UseLog{}, "TakeGridNo"},
+		{&UseLog{}, "ReturnCabinetID"},
+		{&UseLog{}, "ReturnGridNo"},
+		{&UseLog{}, "UseMinutes"},
+		{&UseLog{}, "LiftLoad"},
+		{&UseLog{}, "LiftCount"},
+		{&Sling{}, "WearMinutes"},
+		{&Sling{}, "WearLifts"},
+		{&Sling{}, "WearLoad"},
+		{&Staff{}, "PhotoID"},
+		{&StaffCert{}, "CertFileID"},
+		{&FileObject{}, "Category"},
+		{&Role{}, "CabinetOperator"},
+	}
+}
+
+// AutoMigrate 同步数据表结构，只创建新增的表和字段，不修改已有字段
+func (lgc *Logics) AutoMigrate() error {
+	if err := lgc.db.AutoMigrate(newTables()...); err != nil {
+		return err
+	}
+	migrator := lgc.db.Migrator()
+	for _, col := range newColumns() {
+		if migrator.HasColumn(col.model, col.field) {
+			continue
+		}
+		if err := migrator.AddColumn(col.model, col.field); err != nil {
+			return err
+		}
+	}
+	for _, migrate := range lgc.dataMigrations() {
+		if err := migrate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dataMigrations 表结构同步后执行的数据迁移，每次启动都会执行，需可重复执行
+func (lgc *Logics) dataMigrations() []func() error {
+	return []func() error{
+		// 补齐历史数据中缺少的箱格
+		lgc.provisionAllGrids,
+		// 旧版本自由填写的使用权限
+		lgc.normalizeUsePermission,
+		// 旧版本按员工ID保存的照片，需先初始化文件存储
+		lgc.migrateStaffPhotos,
+	}
+}
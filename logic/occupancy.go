@@ -0,0 +1,328 @@
+package logic
+
+import (
+	"sort"
+	"time"
+
+	"zone.com/common"
+)
+
+// OccupancyParam 智能柜占用率统计参数
+type OccupancyParam struct {
+	CabinetID uint   // 智能柜，0-全部
+	StartTime string // 开始时间，默认30天前
+	EndTime   string // 结束时间，默认当前
+}
+
+// GridOccupancy 箱格占用情况
+type GridOccupancy struct {
+	GridNo          uint    `json:"gridNo"`
+	OccupiedMinutes int64   `json:"occupiedMinutes"` // 有吊索具存放的时长（分钟）
+	OccupiedRate    float64 `json:"occupiedRate"`    // 占用时间百分比
+	EmptyCount      int     `json:"emptyCount"`      // 变为空格的次数
+}
+
+// CabinetOccupancy 智能柜占用情况
+type CabinetOccupancy struct {
+	CabinetID    uint            `json:"cabinetId"`
+	CabinetName  string          `json:"cabinetName"`
+	GridCount    uint            `json:"gridCount"`
+	OccupiedRate float64         `json:"occupiedRate"` // 所有箱格的平均占用时间百分比
+	PeakOccupied int             `json:"peakOccupied"` // 同时占用的最多箱格数
+	PeakTime     *JSONTime       `json:"peakTime"`     // 首次达到最多占用的时间
+	EmptyCount   int             `json:"emptyCount"`   // 箱格变为空格的总次数
+	HourlyRate   [24]float64     `json:"hourlyRate"`   // 每天各小时的平均占用百分比
+	Grids        []GridOccupancy `json:"grids"`
+}
+
+// gridKey 箱格位置
+type gridKey struct {
+	cabinetID uint
+	gridNo    uint
+}
+
+// gridUsage 统计过程中的箱格状态
+type gridUsage struct {
+	count   int       // 存放的吊索具数
+	since   time.Time // 本次占用开始时间
+	minutes float64
+	hourly  [24]float64
+	empty   int
+}
+
+// slingPlace 统计过程中的吊索具位置
+type slingPlace struct {
+	key     gridKey
+	present bool // 在箱格中
+}
+
+// occupancyStat 根据存取事件回放箱格占用情况
+type occupancyStat struct {
+	start, end time.Time
+	cabinets   map[uint]*CabinetOccupancy
+	grids      map[gridKey]*gridUsage
+	occupied   map[uint]int // 智能柜当前占用箱格数
+	slings     map[uint]*slingPlace
+}
+
+// StatCabinetOccupancy 统计智能柜及箱格在时间段内的占用率、占用高峰和空格次数
+// 开始时的占用情况由当前箱格状态撤销之后的存入、移动、借出、归还、删除事件得到，再回放时间段内的事件
+func (lgc *Logics) StatCabinetOccupancy(param *OccupancyParam) (*[]CabinetOccupancy, error) {
+	end := time.Now()
+	if param.EndTime != "" {
+		t, err := parseStatTime(param.EndTime, true)
+		if err != nil {
+			return nil, common.ErrBadQueryParams
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -30)
+	if param.StartTime != "" {
+		t, err := parseStatTime(param.StartTime, false)
+		if err != nil {
+			return nil, common.ErrBadQueryParams
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return nil, common.ErrBadQueryParams
+	}
+
+	var cabinets []Cabinet
+	cabinetdb := lgc.db.Where("deleted_at IS NULL").Order("id")
+	if param.CabinetID > 0 {
+		cabinetdb = cabinetdb.Where("id = ?", param.CabinetID)
+	}
+	if err := cabinetdb.Find(&cabinets).Error; err != nil {
+		return nil, err
+	}
+	stat := &occupancyStat{start: start, end: end, cabinets: map[uint]*CabinetOccupancy{},
+		grids: map[gridKey]*gridUsage{}, occupied: map[uint]int{}, slings: map[uint]*slingPlace{}}
+	for _, v := range cabinets {
+		stat.cabinets[v.ID] = &CabinetOccupancy{CabinetID: v.ID, CabinetName: v.Name, GridCount: v.GridCount}
+		for i := uint(1); i <= v.GridCount; i++ {
+			stat.grids[gridKey{v.ID, i}] = &gridUsage{}
+		}
+	}
+
+	// 从当前箱格状态倒推统计开始时的状态，只回放开始时间之后的事件
+	var grids []CabinetGrid
+	if err := lgc.db.Where("in_res_id > 0 AND deleted_at IS NULL").Find(&grids).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range grids {
+		stat.slings[v.InResID] = &slingPlace{key: gridKey{v.CabinetID, v.GridNo}, present: v.IsOut == 0}
+	}
+	var events []ResEvent
+	if err := lgc.db.Where("event_type IN ? AND res_id > 0 AND created_at >= ? AND deleted_at IS NULL",
+		[]string{EventStore, EventMove, EventTake, EventReturn, EventDelete}, start).
+		Order("created_at, id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		stat.undo(&events[i])
+	}
+	stat.init(start)
+
+	for _, e := range events {
+		t := time.Time(e.CreatedAt)
+		if !t.Before(end) {
+			break
+		}
+		stat.apply(&e, t)
+	}
+	return stat.result(), nil
+}
+
+// undo 撤销一条事件，得到事件发生前吊索具的位置
+func (s *occupancyStat) undo(e *ResEvent) {
+	place := s.slings[e.ResID]
+	from := gridKey{e.FromCabinetID, e.FromGridNo}
+	switch e.EventType {
+	case EventStore:
+		delete(s.slings, e.ResID)
+	case EventMove:
+		present := place == nil || place.present
+		s.slings[e.ResID] = &slingPlace{key: from, present: present}
+	case EventTake:
+		s.slings[e.ResID] = &slingPlace{key: gridKey{e.CabinetID, e.GridNo}, present: true}
+	case EventReturn:
+		if from.cabinetID == 0 {
+			from = gridKey{e.CabinetID, e.GridNo}
+		}
+		s.slings[e.ResID] = &slingPlace{key: from}
+	case EventDelete:
+		// 删除时在箱格中才记录移出的箱格
+		s.slings[e.ResID] = &slingPlace{key: from, present: from.cabinetID > 0}
+	}
+}
+
+// init 按开始时吊索具的位置设置箱格状态
+func (s *occupancyStat) init(start time.Time) {
+	for _, place := range s.slings {
+		if !place.present {
+			continue
+		}
+		grid := s.grids[place.key]
+		if grid == nil {
+			continue
+		}
+		if grid.count == 0 {
+			s.occupied[place.key.cabinetID]++
+		}
+		grid.count++
+		grid.since = start
+	}
+	s.checkPeak(start)
+}
+
+// apply 回放一条事件
+func (s *occupancyStat) apply(e *ResEvent, t time.Time) {
+	place := s.slings[e.ResID]
+	to := gridKey{e.CabinetID, e.GridNo}
+	switch e.EventType {
+	case EventStore, EventReturn:
+		if place != nil && place.present {
+			s.change(place.key, -1, t)
+		}
+		place = &slingPlace{key: to, present: to.cabinetID > 0}
+		if place.present {
+			s.change(to, 1, t)
+		}
+	case EventMove:
+		present := true
+		if place != nil {
+			present = place.present
+			if present {
+				s.change(place.key, -1, t)
+			}
+		}
+		place = &slingPlace{key: to, present: present}
+		if present {
+			s.change(to, 1, t)
+		}
+	case EventTake:
+		if place == nil {
+			place = &slingPlace{key: to}
+		} else if place.present {
+			s.change(place.key, -1, t)
+		}
+		place.present = false
+	case EventDelete:
+		if place != nil && place.present {
+			s.change(place.key, -1, t)
+		}
+		delete(s.slings, e.ResID)
+		return
+	}
+	s.slings[e.ResID] = place
+}
+
+// change 箱格存放的吊索具数变化
+func (s *occupancyStat) change(key gridKey, delta int, t time.Time) {
+	grid := s.grids[key]
+	if grid == nil {
+		return
+	}
+	if grid.count > 0 {
+		s.accumulate(grid, grid.since, t)
+	}
+	wasOccupied := grid.count > 0
+	grid.count += delta
+	if grid.count < 0 {
+		grid.count = 0
+	}
+	grid.since = t
+	inRange := !t.Before(s.start)
+	if wasOccupied && grid.count == 0 {
+		s.occupied[key.cabinetID]--
+		if inRange {
+			grid.empty++
+		}
+	} else if !wasOccupied && grid.count > 0 {
+		s.occupied[key.cabinetID]++
+		if inRange {
+			s.checkPeak(t)
+		}
+	}
+}
+
+// checkPeak 记录智能柜的占用高峰
+func (s *occupancyStat) checkPeak(t time.Time) {
+	for id, cabinet := range s.cabinets {
+		if s.occupied[id] > cabinet.PeakOccupied {
+			cabinet.PeakOccupied = s.occupied[id]
+			peak := JSONTime(t)
+			cabinet.PeakTime = &peak
+		}
+	}
+}
+
+// accumulate 累计箱格在统计时间段内的占用时长
+func (s *occupancyStat) accumulate(grid *gridUsage, from, to time.Time) {
+	grid.minutes += spreadHours(&grid.hourly, from, to, s.start, s.end)
+}
+
+// spreadHours 将时间段按小时累计分钟数，只统计[start, end)内的部分，返回总分钟数
+func spreadHours(hourly *[24]float64, from, to, start, end time.Time) float64 {
+	if from.Before(start) {
+		from = start
+	}
+	if to.After(end) {
+		to = end
+	}
+	total := 0.0
+	for from.Before(to) {
+		next := from.Truncate(time.Hour).Add(time.Hour)
+		if next.After(to) {
+			next = to
+		}
+		minutes := next.Sub(from).Minutes()
+		hourly[from.Hour()] += minutes
+		total += minutes
+		from = next
+	}
+	return total
+}
+
+// result 汇总统计结果
+func (s *occupancyStat) result() *[]CabinetOccupancy {
+	var rangeHourly [24]float64
+	rangeMinutes := spreadHours(&rangeHourly, s.start, s.end, s.start, s.end)
+
+	result := make([]CabinetOccupancy, 0, len(s.cabinets))
+	for id, cabinet := range s.cabinets {
+		var minutes float64
+		var hourly [24]float64
+		for i := uint(1); i <= cabinet.GridCount; i++ {
+			grid := s.grids[gridKey{id, i}]
+			if grid.count > 0 {
+				s.accumulate(grid, grid.since, s.end)
+			}
+			minutes += grid.minutes
+			for h := range hourly {
+				hourly[h] += grid.hourly[h]
+			}
+			cabinet.EmptyCount += grid.empty
+			cabinet.Grids = append(cabinet.Grids, GridOccupancy{GridNo: i, OccupiedMinutes: int64(grid.minutes),
+				OccupiedRate: percent(grid.minutes, rangeMinutes), EmptyCount: grid.empty})
+		}
+		cabinet.OccupiedRate = percent(minutes, rangeMinutes*float64(cabinet.GridCount))
+		for h := range hourly {
+			cabinet.HourlyRate[h] = percent(hourly[h], rangeHourly[h]*float64(cabinet.GridCount))
+		}
+		result = append(result, *cabinet)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CabinetID < result[j].CabinetID
+	})
+	return &result
+}
+
+// percent 百分比，保留两位小数
+func percent(a, b float64) float64 {
+	if b <= 0 {
+		return 0
+	}
+	return float64(int64(a/b*10000+0.5)) / 100
+}
@@ -0,0 +1,159 @@
+package logic
+
+import (
+	"sort"
+	"strings"
+
+	"zone.com/common"
+)
+
+// AutoStoreParam 自动存放参数
+type AutoStoreParam struct {
+	ResID     uint   `json:"resId"`
+	CabinetID uint   `json:"cabinetId"` // 优先存放的智能柜，0-不限
+	Location  string `json:"location"`  // 优先存放的位置，空-不限
+}
+
+// GridLocation 箱格位置
+type GridLocation struct {
+	CabinetID   uint   `json:"cabinetId"`
+	CabinetName string `json:"cabinetName"`
+	Location    string `json:"location"`
+	GridNo      uint   `json:"gridNo"`
+}
+
+// placementCabinet 智能柜的存放情况
+type placementCabinet struct {
+	gridCount uint
+	usedCount uint
+	sameType  []uint // 存放同类型吊索具的箱格编号
+}
+
+// AutoStore 自动选择空闲箱格存放吊索具
+func (lgc *Logics) AutoStore(param *AutoStoreParam) (*GridLocation, error) {
+	sling, err := lgc.QuerySlingByID(param.ResID)
+	if err != nil {
+		return nil, common.ErrNotFound
+	}
+	location, err := lgc.ChooseGrid(sling, param.CabinetID, param.Location)
+	if err != nil {
+		return nil, err
+	}
+	if err := lgc.Store(location.CabinetID, location.GridNo, sling.ID); err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// ChooseGrid 为吊索具选择空闲箱格：
+// 满足箱格尺寸和承重限制，优先选择指定的智能柜或位置，同类型吊索具集中存放，其次选择使用率低的智能柜
+func (lgc *Logics) ChooseGrid(sling *Sling, cabinetID uint, location string) (*GridLocation, error) {
+	var cabinets []Cabinet
+	if err := lgc.db.Where("deleted_at IS NULL").Find(&cabinets).Error; err != nil {
+		return nil, err
+	}
+
+	// 空闲箱格
+	var candidates []GridLocation
+	if err := lgc.db.Table("t_res_cabinet_grid").
+		Select("t_res_cabinet_grid.cabinet_id, t_res_cabinet.name AS cabinet_name, t_res_cabinet.location, t_res_cabinet_grid.grid_no").
+		Joins("JOIN t_res_cabinet ON t_res_cabinet.id = t_res_cabinet_grid.cabinet_id").
+		Where("t_res_cabinet.deleted_at IS NULL AND t_res_cabinet_grid.deleted_at IS NULL").
+		Where("t_res_cabinet_grid.grid_no <= t_res_cabinet.grid_count").
+		Where("t_res_cabinet_grid.in_res_id = 0 AND t_res_cabinet_grid.status = ?", GridStatusNormal).
+		Where("t_res_cabinet_grid.size_class = 0 OR t_res_cabinet_grid.size_class >= ?", sling.SizeClass).
+		Where("t_res_cabinet_grid.max_tonnage = 0 OR t_res_cabinet_grid.max_tonnage >= ?", sling.MaxTonnage).
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, common.ErrNoFreeGrid
+	}
+
+	// 各智能柜的存放情况
+	stats := make(map[uint]*placementCabinet, len(cabinets))
+	for _, cabinet := range cabinets {
+		stats[cabinet.ID] = &placementCabinet{gridCount: cabinet.GridCount}
+	}
+	var used []struct {
+		CabinetID uint
+		GridNo    uint
+		SlingType uint
+	}
+	if err := lgc.db.Table("t_res_cabinet_grid").
+		Select("t_res_cabinet_grid.cabinet_id, t_res_cabinet_grid.grid_no, t_res_sling.sling_type").
+		Joins("JOIN t_res_sling ON t_res_sling.id = t_res_cabinet_grid.in_res_id").
+		Where("t_res_cabinet_grid.deleted_at IS NULL AND t_res_sling.deleted_at IS NULL").
+		Scan(&used).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range used {
+		stat, ok := stats[v.CabinetID]
+		if !ok {
+			continue
+		}
+		stat.usedCount++
+		if v.SlingType == sling.SlingType {
+			stat.sameType = append(stat.sameType, v.GridNo)
+		}
+	}
+
+	// 优先的智能柜或位置有空闲箱格时只在其中选择
+	preferred := candidates[:0:0]
+	for _, v := range candidates {
+		if (cabinetID > 0 && v.CabinetID == cabinetID) ||
+			(cabinetID == 0 && location != "" && strings.Contains(v.Location, location)) {
+			preferred = append(preferred, v)
+		}
+	}
+	if len(preferred) > 0 {
+		candidates = preferred
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := stats[candidates[i].CabinetID], stats[candidates[j].CabinetID]
+		// 同类型吊索具多的智能柜
+		if len(a.sameType) != len(b.sameType) {
+			return len(a.sameType) > len(b.sameType)
+		}
+		// 使用率低的智能柜
+		ra, rb := a.usedRate(), b.usedRate()
+		if ra != rb {
+			return ra < rb
+		}
+		if candidates[i].CabinetID != candidates[j].CabinetID {
+			return candidates[i].CabinetID < candidates[j].CabinetID
+		}
+		// 靠近同类型吊索具的箱格
+		da, db := a.distance(candidates[i].GridNo), b.distance(candidates[j].GridNo)
+		if da != db {
+			return da < db
+		}
+		return candidates[i].GridNo < candidates[j].GridNo
+	})
+
+	return &candidates[0], nil
+}
+
+// usedRate 智能柜使用率
+func (p *placementCabinet) usedRate() float64 {
+	if p.gridCount == 0 {
+		return 1
+	}
+	return float64(p.usedCount) / float64(p.gridCount)
+}
+
+// distance 箱格与最近的同类型吊索具箱格的距离
+func (p *placementCabinet) distance(gridNo uint) uint {
+	min := ^uint(0)
+	for _, no := range p.sameType {
+		d := no - gridNo
+		if no < gridNo {
+			d = gridNo - no
+		}
+		if d < min {
+			min = d
+		}
+	}
+	return min
+}
@@ -0,0 +1,200 @@
+package logic
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// slingReportRow 吊索具报表行
+type slingReportRow struct {
+	RfID          string
+	Name          string
+	SlingType     uint
+	MaxTonnage    uint
+	UseStatus     uint
+	InspectStatus uint
+	PutTime       string
+	WearMinutes   int64
+	WearLifts     int64
+	WearLoad      int64
+	WearFlag      int16
+	CabinetName   string
+	GridNo        uint
+	IsOut         uint
+	UseCount      int
+}
+
+// reportDicts 报表中使用的字典名称
+type reportDicts map[string]map[int]string
+
+func (lgc *Logics) reportDicts() (reportDicts, error) {
+	var dicts []DictData
+	if err := lgc.db.Find(&dicts).Error; err != nil {
+		return nil, err
+	}
+	result := reportDicts{}
+	for _, v := range dicts {
+		if result[v.Type] == nil {
+			result[v.Type] = map[int]string{}
+		}
+		result[v.Type][v.Key] = v.Name
+	}
+	return result, nil
+}
+
+// name 字典名称，没有对应字典时显示原值
+func (d reportDicts) name(dictType string, key uint) string {
+	if name, ok := d[dictType][int(key)]; ok {
+		return name
+	}
+	return fmt.Sprint(key)
+}
+
+// cabinetNames 智能柜名称
+func (lgc *Logics) cabinetNames() (map[uint]string, error) {
+	var cabinets []Cabinet
+	if err := lgc.db.Select("id, name").Find(&cabinets).Error; err != nil {
+		return nil, err
+	}
+	result := map[uint]string{}
+	for _, v := range cabinets {
+		result[v.ID] = v.Name
+	}
+	return result, nil
+}
+
+// gridLocation 箱格位置文字
+func gridLocation(cabinetName string, gridNo uint) string {
+	if cabinetName == "" && gridNo == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", cabinetName, gridNo)
+}
+
+// reportTime 报表时间文字
+func reportTime(t *JSONTime) string {
+	if t == nil || time.Time(*t).IsZero() {
+		return ""
+	}
+	return t.String()
+}
+
+// writeReport 逐行读取查询结果写入报表
+func (lgc *Logics) writeReport(w io.Writer, format string, title string, columns []reportColumn,
+	query *gorm.DB, row func(rows *sql.Rows) ([]interface{}, error)) error {
+	writer, err := newReportWriter(w, format, fmt.Sprintf("%s（%s）", title, time.Now().Format(localDateTimeFormat)), columns)
+	if err != nil {
+		return err
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values, err := row(rows)
+		if err != nil {
+			return err
+		}
+		if err := writer.writeRow(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writer.close()
+}
+
+// ReportUseLogs 取还记录报表，查询条件同取还日志
+func (lgc *Logics) ReportUseLogs(w io.Writer, format string, param *UseLogQueryParam) error {
+	cabinets, err := lgc.cabinetNames()
+	if err != nil {
+		return err
+	}
+	columns := []reportColumn{{"吊索具", 16}, {"借用人", 8}, {"借用时间", 18}, {"预计归还时间", 18}, {"借出位置", 12},
+		{"归还人", 8}, {"归还时间", 18}, {"归还位置", 12}, {"使用时长(分钟)", 10}, {"说明", 20}}
+	return lgc.writeReport(w, format, "吊索具取还记录", columns, lgc.useLogQuery(param), func(rows *sql.Rows) ([]interface{}, error) {
+		var log UseLog
+		if err := lgc.db.ScanRows(rows, &log); err != nil {
+			return nil, err
+		}
+		return []interface{}{log.ResName, log.TakeStaffName, reportTime(log.TakeTime), reportTime(&log.ReturnPlanTime),
+			gridLocation(cabinets[log.TakeCabinetID], log.TakeGridNo), log.ReturnStaffName, reportTime(log.ReturnTime),
+			gridLocation(cabinets[log.ReturnCabinetID], log.ReturnGridNo), log.UseMinutes, log.Remark}, nil
+	})
+}
+
+// ReportOverdue 逾期未还报表，查询条件同取还日志
+func (lgc *Logics) ReportOverdue(w io.Writer, format string, param *UseLogQueryParam) error {
+	cabinets, err := lgc.cabinetNames()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	query := lgc.useLogQuery(param).
+		Where("t_res_use_log.return_time IS NULL AND t_res_use_log.return_plan_time < ?", now)
+	columns := []reportColumn{{"吊索具", 16}, {"借用人", 8}, {"借用时间", 18}, {"预计归还时间", 18},
+		{"逾期(小时)", 8}, {"借出位置", 12}, {"说明", 20}}
+	return lgc.writeReport(w, format, "逾期未还吊索具", columns, query, func(rows *sql.Rows) ([]interface{}, error) {
+		var log UseLog
+		if err := lgc.db.ScanRows(rows, &log); err != nil {
+			return nil, err
+		}
+		hours := int64(now.Sub(time.Time(log.ReturnPlanTime)).Hours())
+		return []interface{}{log.ResName, log.TakeStaffName, reportTime(log.TakeTime), reportTime(&log.ReturnPlanTime),
+			hours, gridLocation(cabinets[log.TakeCabinetID], log.TakeGridNo), log.Remark}, nil
+	})
+}
+
+// ReportSlings 吊索具库存报表，查询条件同吊索具列表
+func (lgc *Logics) ReportSlings(w io.Writer, format string, param *SlingQueryParam) error {
+	dicts, err := lgc.reportDicts()
+	if err != nil {
+		return err
+	}
+	columns := []reportColumn{{"名称", 16}, {"RFID", 16}, {"类型", 10}, {"吨位", 8}, {"使用状态", 8},
+		{"点检状态", 8}, {"存放位置", 12}, {"在柜", 6}, {"使用次数", 8}, {"入库时间", 18}}
+	query := lgc.slingQuery(param).Order("t_res_sling.id")
+	return lgc.writeReport(w, format, "吊索具库存", columns, query, func(rows *sql.Rows) ([]interface{}, error) {
+		var sling slingReportRow
+		if err := lgc.db.ScanRows(rows, &sling); err != nil {
+			return nil, err
+		}
+		inCabinet := ""
+		if sling.CabinetName != "" {
+			inCabinet = "是"
+			if sling.IsOut == 1 {
+				inCabinet = "否"
+			}
+		}
+		return []interface{}{sling.Name, sling.RfID, dicts.name("SLING_TYPE", sling.SlingType), dicts.name("TON_TYPE", sling.MaxTonnage),
+			dicts.name("USE_STATUS_TYPE", sling.UseStatus), dicts.name("INSPECT_STATUS_TYPE", sling.InspectStatus),
+			gridLocation(sling.CabinetName, sling.GridNo), inCabinet, sling.UseCount, sling.PutTime}, nil
+	})
+}
+
+// ReportInspection 点检状态报表，按点检状态和磨损状态排序，查询条件同吊索具列表
+func (lgc *Logics) ReportInspection(w io.Writer, format string, param *SlingQueryParam) error {
+	dicts, err := lgc.reportDicts()
+	if err != nil {
+		return err
+	}
+	wearNames := map[int16]string{WearNormal: "正常", WearInspect: "需点检", WearRetire: "需报废"}
+	columns := []reportColumn{{"名称", 16}, {"类型", 10}, {"吨位", 8}, {"点检状态", 8}, {"磨损状态", 8},
+		{"累计时长(分钟)", 10}, {"累计起吊次数", 10}, {"累计载荷(吨)", 10}, {"存放位置", 12}}
+	query := lgc.slingQuery(param).Order("t_res_sling.inspect_status, wear_flag DESC, t_res_sling.id")
+	return lgc.writeReport(w, format, "吊索具点检状态", columns, query, func(rows *sql.Rows) ([]interface{}, error) {
+		var sling slingReportRow
+		if err := lgc.db.ScanRows(rows, &sling); err != nil {
+			return nil, err
+		}
+		return []interface{}{sling.Name, dicts.name("SLING_TYPE", sling.SlingType), dicts.name("TON_TYPE", sling.MaxTonnage),
+			dicts.name("INSPECT_STATUS_TYPE", sling.InspectStatus), wearNames[sling.WearFlag],
+			sling.WearMinutes, sling.WearLifts, sling.WearLoad, gridLocation(sling.CabinetName, sling.GridNo)}, nil
+	})
+}
@@ -0,0 +1,205 @@
+package logic
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+const (
+	// ReportXLSX Excel报表
+	ReportXLSX = "xlsx"
+	// ReportPDF PDF报表
+	ReportPDF = "pdf"
+)
+
+// ReportContentTypes 报表文件类型
+var ReportContentTypes = map[string]string{
+	ReportXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	ReportPDF:  "application/pdf",
+}
+
+// CheckReportFormat 检查报表格式，PDF报表需配置中文字体
+func CheckReportFormat(format string) error {
+	switch format {
+	case ReportXLSX:
+		return nil
+	case ReportPDF:
+		if util.PDFFont == "" {
+			return common.ErrReportFontNotSet
+		}
+		return nil
+	}
+	return common.ErrReportFormat
+}
+
+// reportColumn 报表列，宽度为字符数
+type reportColumn struct {
+	title string
+	width float64
+}
+
+// reportWriter 逐行写入报表
+type reportWriter interface {
+	writeRow(values []interface{}) error
+	close() error
+}
+
+// newReportWriter 创建报表并写入标题和表头
+func newReportWriter(w io.Writer, format string, title string, columns []reportColumn) (reportWriter, error) {
+	if err := CheckReportFormat(format); err != nil {
+		return nil, err
+	}
+	if format == ReportPDF {
+		return newPDFReport(w, title, columns)
+	}
+	return newXLSXReport(w, title, columns)
+}
+
+// xlsxReport Excel报表，按行流式写入
+type xlsxReport struct {
+	w    io.Writer
+	file *excelize.File
+	sw   *excelize.StreamWriter
+	row  int
+}
+
+func newXLSXReport(w io.Writer, title string, columns []reportColumn) (*xlsxReport, error) {
+	file := excelize.NewFile()
+	sw, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		return nil, err
+	}
+	bold, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	for i, col := range columns {
+		if err := sw.SetColWidth(i+1, i+1, col.width+2); err != nil {
+			return nil, err
+		}
+	}
+	r := &xlsxReport{w: w, file: file, sw: sw}
+	if err := r.writeRow([]interface{}{excelize.Cell{StyleID: bold, Value: title}}); err != nil {
+		return nil, err
+	}
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = excelize.Cell{StyleID: bold, Value: col.title}
+	}
+	if err := r.writeRow(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *xlsxReport) writeRow(values []interface{}) error {
+	r.row++
+	cell, err := excelize.CoordinatesToCellName(1, r.row)
+	if err != nil {
+		return err
+	}
+	return r.sw.SetRow(cell, values)
+}
+
+func (r *xlsxReport) close() error {
+	if err := r.sw.Flush(); err != nil {
+		return err
+	}
+	return r.file.Write(r.w)
+}
+
+// pdfReport PDF报表，A4横向，每页重复表头
+type pdfReport struct {
+	w      io.Writer
+	pdf    *gofpdf.Fpdf
+	widths []float64
+}
+
+const (
+	pdfFontName   = "report"
+	pdfFontSize   = 9
+	pdfLineHeight = 6
+)
+
+func newPDFReport(w io.Writer, title string, columns []reportColumn) (*pdfReport, error) {
+	font, err := os.ReadFile(util.PDFFont)
+	if err != nil {
+		return nil, err
+	}
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(pdfFontName, "", font)
+	pdf.SetFont(pdfFontName, "", pdfFontSize)
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+
+	// 列宽按字符数比例占满页面宽度
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	total := 0.0
+	for _, col := range columns {
+		total += col.width
+	}
+	widths := make([]float64, len(columns))
+	for i, col := range columns {
+		widths[i] = (pageWidth - left - right) * col.width / total
+	}
+
+	r := &pdfReport{w: w, pdf: pdf, widths: widths}
+	pdf.SetHeaderFunc(func() {
+		pdf.SetFont(pdfFontName, "", pdfFontSize+3)
+		pdf.CellFormat(0, pdfLineHeight*1.5, title, "", 1, "C", false, 0, "")
+		pdf.SetFont(pdfFontName, "", pdfFontSize)
+		pdf.SetFillColor(230, 230, 230)
+		for i, col := range columns {
+			pdf.CellFormat(widths[i], pdfLineHeight, r.fit(col.title, widths[i]), "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+	})
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.CellFormat(0, pdfLineHeight, fmt.Sprintf("%d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+	return r, pdf.Error()
+}
+
+func (r *pdfReport) writeRow(values []interface{}) error {
+	for i, width := range r.widths {
+		text := ""
+		if i < len(values) && values[i] != nil {
+			text = fmt.Sprint(values[i])
+		}
+		r.pdf.CellFormat(width, pdfLineHeight, r.fit(text, width), "1", 0, "L", false, 0, "")
+	}
+	r.pdf.Ln(-1)
+	return r.pdf.Error()
+}
+
+// fit 截断超出单元格宽度的文字
+func (r *pdfReport) fit(text string, width float64) string {
+	return fitText(r.pdf, text, width)
+}
+
+// fitText 截断超出单元格宽度的文字
+func fitText(pdf *gofpdf.Fpdf, text string, width float64) string {
+	max := width - 2*pdf.GetCellMargin()
+	if pdf.GetStringWidth(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"…") > max {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+func (r *pdfReport) close() error {
+	return r.pdf.Output(r.w)
+}
@@ -0,0 +1,251 @@
+package logic
+
+import (
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+// Reservation 吊索具预约
+type Reservation struct {
+	BaseModel
+	ResID      uint     `json:"resId"` // 预约的吊索具ID，0-按类型预约
+	ResName    string   `json:"resName" gorm:"size:64"`
+	SlingType  uint     `json:"slingType"`  // 吊索具类型
+	MinTonnage uint     `json:"minTonnage"` // 按类型预约时的最小吨位
+	StaffID    uint     `json:"staffId"`
+	StaffName  string   `json:"staffName" gorm:"size:64"`
+	StartTime  JSONTime `json:"startTime" gorm:"type:timestamp"` // 预约开始时间
+	EndTime    JSONTime `json:"endTime" gorm:"type:timestamp"`   // 预约结束时间
+	Status     int16    `json:"status"`                          // 状态：0-待取用，1-已取用，2-已取消，3-已过期
+	UseLogID   uint     `json:"useLogId"`                        // 取用的借还记录
+	Remark     string   `json:"remark"`                          // 说明
+}
+
+// TableName Reservation
+func (Reservation) TableName() string {
+	return "t_res_reservation"
+}
+
+const (
+	// ReservationWaiting 待取用
+	ReservationWaiting int16 = 0
+	// ReservationTaken 已取用
+	ReservationTaken int16 = 1
+	// ReservationCanceled 已取消
+	ReservationCanceled int16 = 2
+	// ReservationExpired 已过期
+	ReservationExpired int16 = 3
+)
+
+// ReservationQueryParam 预约查询参数
+type ReservationQueryParam struct {
+	ResID   uint
+	StaffID uint
+	Status  int16 // -1-全部
+}
+
+// AddReservation 添加预约
+func (lgc *Logics) AddReservation(r *Reservation) error {
+	startTime, endTime := time.Time(r.StartTime), time.Time(r.EndTime)
+	if !startTime.Before(endTime) || startTime.Before(time.Now().Add(-util.ReservationGrace)) {
+		return common.ErrReservationTime
+	}
+	staff, err := lgc.QueryStaffByID(r.StaffID)
+	if err != nil {
+		return common.ErrReservationStaffIsNull
+	}
+	if err := lgc.ExpireReservations(); err != nil {
+		return err
+	}
+	r.StaffName = staff.Name
+	r.Status = ReservationWaiting
+	r.UseLogID = 0
+
+	if r.ResID > 0 {
+		// 指定吊索具
+		sling, err := lgc.QuerySlingByID(r.ResID)
+		if err != nil {
+			return common.ErrNotFound
+		}
+		r.ResName = sling.Name
+		r.SlingType = sling.SlingType
+		r.MinTonnage = 0
+		var count int64
+		if err := lgc.db.Model(&Reservation{}).
+			Where("res_id = ? AND status = ? AND start_time < ? AND end_time > ?", r.ResID, ReservationWaiting, endTime, startTime).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return common.ErrReservationConflict
+		}
+		// 未归还的借用
+		if err := lgc.db.Model(&UseLog{}).
+			Where("res_id = ? AND return_time IS NULL AND (return_plan_time IS NULL OR return_plan_time > ?)", r.ResID, startTime).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return common.ErrReservationConflict
+		}
+	} else {
+		// 按类型和吨位预约
+		if r.SlingType == 0 {
+			return common.ErrBadQueryParams
+		}
+		r.ResName = ""
+		var free int64
+		if err := lgc.db.Model(&Sling{}).
+			Where("deleted_at IS NULL AND sling_type = ? AND max_tonnage >= ?", r.SlingType, r.MinTonnage).
+			Where("NOT EXISTS (SELECT 1 FROM t_res_reservation WHERE t_res_reservation.res_id = t_res_sling.id AND t_res_reservation.status = ? AND t_res_reservation.start_time < ? AND t_res_reservation.end_time > ?)",
+				ReservationWaiting, endTime, startTime).
+			Where("NOT EXISTS (SELECT 1 FROM t_res_use_log WHERE t_res_use_log.res_id = t_res_sling.id AND t_res_use_log.return_time IS NULL AND (t_res_use_log.return_plan_time IS NULL OR t_res_use_log.return_plan_time > ?))",
+				startTime).
+			Count(&free).Error; err != nil {
+			return err
+		}
+		// 同时段同类型的其他预约
+		var booked int64
+		if err := lgc.db.Model(&Reservation{}).
+			Where("res_id = 0 AND sling_type = ? AND status = ? AND start_time < ? AND end_time > ?", r.SlingType, ReservationWaiting, endTime, startTime).
+			Count(&booked).Error; err != nil {
+			return err
+		}
+		if free <= booked {
+			return common.ErrReservationConflict
+		}
+	}
+
+	if err := lgc.db.Create(&r).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// CancelReservation 取消预约
+func (lgc *Logics) CancelReservation(id uint) error {
+	var r Reservation
+	if err := lgc.db.Where("id = ?", id).First(&r).Error; err != nil {
+		return common.ErrNotFound
+	}
+	if r.Status != ReservationWaiting {
+		return common.ErrNoUpdate
+	}
+	if err := lgc.db.Model(&r).Update("status", ReservationCanceled).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListReservations 查询预约
+func (lgc *Logics) ListReservations(param *ReservationQueryParam, pageIndex int, pageSize int) (*SearchResult, error) {
+	if err := lgc.ExpireReservations(); err != nil {
+		return nil, err
+	}
+	reservedb := lgc.db.Model(&Reservation{}).Order("start_time desc")
+	if param.ResID > 0 {
+		reservedb = reservedb.Where("res_id = ?", param.ResID)
+	}
+	if param.StaffID > 0 {
+		reservedb = reservedb.Where("staff_id = ?", param.StaffID)
+	}
+	if param.Status > -1 {
+		reservedb = reservedb.Where("status = ?", param.Status)
+	}
+	if pageIndex == 0 {
+		pageIndex = 1
+	}
+	if pageSize == 0 {
+		pageSize = 10
+	}
+	var rowCount int64
+	reservedb.Count(&rowCount)                                         //总行数
+	pageCount := int(math.Ceil(float64(rowCount) / float64(pageSize))) // 总页数
+
+	var reservations []Reservation
+	if err := reservedb.Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&reservations).Error; err != nil {
+		return nil, err
+	}
+
+	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &reservations}, nil
+}
+
+// ExpireReservations 过期预约：超过结束时间或开始后超过宽限时间仍未取用
+func (lgc *Logics) ExpireReservations() error {
+	now := time.Now()
+	return lgc.db.Model(&Reservation{}).
+		Where("status = ? AND (end_time < ? OR start_time < ?)", ReservationWaiting, now, now.Add(-util.ReservationGrace)).
+		Update("status", ReservationExpired).Error
+}
+
+// checkReservation 借出的吊索具不能与其他员工的预约冲突
+func checkReservation(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	now := time.Now()
+	planTime := time.Time(useLog.ReturnPlanTime)
+	if planTime.Before(now) {
+		planTime = now
+	}
+	var count int64
+	if err := db.Model(&Reservation{}).
+		Where("res_id = ? AND staff_id <> ? AND status = ?", sling.ID, useLog.TakeStaffID, ReservationWaiting).
+		Where("start_time <= ? AND end_time >= ?", planTime.Add(util.ReservationGrace), now).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.ErrSlingReserved
+	}
+	// 其他员工按类型的预约，借出后剩余的同类型吊索具不能少于预约数
+	var booked []Reservation
+	if err := db.Where("res_id = 0 AND sling_type = ? AND min_tonnage <= ? AND staff_id <> ? AND status = ?",
+		sling.SlingType, sling.MaxTonnage, useLog.TakeStaffID, ReservationWaiting).
+		Where("start_time <= ? AND end_time >= ?", planTime.Add(util.ReservationGrace), now).
+		Find(&booked).Error; err != nil {
+		return err
+	}
+	if len(booked) == 0 {
+		return nil
+	}
+	// 只计算能满足所有这些预约吨位的吊索具
+	minTonnage := uint(0)
+	for _, r := range booked {
+		if r.MinTonnage > minTonnage {
+			minTonnage = r.MinTonnage
+		}
+	}
+	var free int64
+	if err := db.Model(&Sling{}).
+		Where("deleted_at IS NULL AND id <> ? AND sling_type = ? AND max_tonnage >= ?", sling.ID, sling.SlingType, minTonnage).
+		Where("NOT EXISTS (SELECT 1 FROM t_res_reservation WHERE t_res_reservation.res_id = t_res_sling.id AND t_res_reservation.status = ? AND t_res_reservation.start_time <= ? AND t_res_reservation.end_time >= ?)",
+			ReservationWaiting, planTime.Add(util.ReservationGrace), now).
+		Where("NOT EXISTS (SELECT 1 FROM t_res_use_log WHERE t_res_use_log.res_id = t_res_sling.id AND t_res_use_log.return_time IS NULL)").
+		Count(&free).Error; err != nil {
+		return err
+	}
+	if free < int64(len(booked)) {
+		return common.ErrSlingReserved
+	}
+	return nil
+}
+
+// useReservation 借出时使用员工对该吊索具的预约
+func useReservation(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	now := time.Now()
+	var r Reservation
+	err := db.Where("staff_id = ? AND status = ? AND start_time <= ? AND end_time >= ?",
+		useLog.TakeStaffID, ReservationWaiting, now.Add(util.ReservationGrace), now).
+		Where("res_id = ? OR (res_id = 0 AND sling_type = ? AND min_tonnage <= ?)", sling.ID, sling.SlingType, sling.MaxTonnage).
+		Order("res_id desc, start_time").
+		First(&r).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.Model(&r).Updates(map[string]interface{}{"Status": ReservationTaken, "UseLogID": useLog.ID}).Error
+}
@@ -0,0 +1,109 @@
+package logic
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ResEvent 资源事件
+type ResEvent struct {
+	BaseModel
+	ResID           uint   `json:"resId"`                                 // 吊索具ID，智能柜事件为0
+	EventType       string `json:"eventType" gorm:"size:32"`              // 事件类型
+	CabinetID       uint   `json:"cabinetId"`                             // 智能柜
+	CabinetName     string `json:"cabinetName" gorm:"->;-:migration"`     // 智能柜名称
+	GridNo          uint   `json:"gridNo"`                                // 箱格
+	FromCabinetID   uint   `json:"fromCabinetId"`                         // 移出的智能柜
+	FromCabinetName string `json:"fromCabinetName" gorm:"->;-:migration"` // 移出的智能柜名称
+	FromGridNo      uint   `json:"fromGridNo"`                            // 移出的箱格
+	StaffID         uint   `json:"staffId"`                               // 相关员工
+	StaffName       string `json:"staffName" gorm:"size:64"`              // 相关员工姓名
+	UseLogID        uint   `json:"useLogId"`                              // 借还记录
+	Content         string `json:"content"`                               // 事件内容
+}
+
+// TableName ResEvent
+func (ResEvent) TableName() string {
+	return "t_res_event"
+}
+
+const (
+	// EventCreate 创建
+	EventCreate = "create"
+	// EventUpdate 修改
+	EventUpdate = "update"
+	// EventDelete 删除
+	EventDelete = "delete"
+	// EventStore 存入箱格
+	EventStore = "store"
+	// EventMove 移动箱格
+	EventMove = "move"
+	// EventTake 借出
+	EventTake = "take"
+	// EventReturn 归还
+	EventReturn = "return"
+	// EventInspect 点检状态变更
+	EventInspect = "inspect"
+	// EventStatus 使用状态变更
+	EventStatus = "status"
+	// EventCommand 智能柜远程指令
+	EventCommand = "command"
+)
+
+// recordEvent 记录资源事件
+func recordEvent(db *gorm.DB, event *ResEvent) error {
+	return db.Create(event).Error
+}
+
+// GetSlingHistory 吊索具的完整履历，按时间排序
+func (lgc *Logics) GetSlingHistory(resID uint) (*[]ResEvent, error) {
+	var events []ResEvent
+	if err := lgc.db.Table("t_res_event").
+		Select("t_res_event.*, c1.name AS cabinet_name, c2.name AS from_cabinet_name").
+		Joins("LEFT JOIN t_res_cabinet AS c1 ON c1.id = t_res_event.cabinet_id").
+		Joins("LEFT JOIN t_res_cabinet AS c2 ON c2.id = t_res_event.from_cabinet_id").
+		Where("t_res_event.res_id = ? AND t_res_event.deleted_at IS NULL", resID).
+		Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	// 记录事件之前的历史数据：吊索具创建
+	hasCreate := false
+	for _, v := range events {
+		if v.EventType == EventCreate {
+			hasCreate = true
+			break
+		}
+	}
+	if !hasCreate {
+		if sling, err := lgc.QuerySlingByID(resID); err == nil {
+			events = append(events, ResEvent{BaseModel: BaseModel{CreatedAt: sling.CreatedAt}, ResID: resID, EventType: EventCreate, Content: sling.Name})
+		}
+	}
+
+	// 记录事件之前的历史数据：借还记录
+	var logs []UseLog
+	if err := lgc.db.Where("res_id = ? AND id NOT IN (SELECT use_log_id FROM t_res_event WHERE res_id = ? AND use_log_id > 0)", resID, resID).
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	for _, log := range logs {
+		takeTime := log.CreatedAt
+		if log.TakeTime != nil {
+			takeTime = *log.TakeTime
+		}
+		events = append(events, ResEvent{BaseModel: BaseModel{CreatedAt: takeTime}, ResID: resID, EventType: EventTake,
+			CabinetID: log.TakeCabinetID, GridNo: log.TakeGridNo, StaffID: log.TakeStaffID, StaffName: log.TakeStaffName, UseLogID: log.ID})
+		if log.ReturnTime != nil {
+			events = append(events, ResEvent{BaseModel: BaseModel{CreatedAt: *log.ReturnTime}, ResID: resID, EventType: EventReturn,
+				CabinetID: log.ReturnCabinetID, GridNo: log.ReturnGridNo, StaffID: log.ReturnStaffID, StaffName: log.ReturnStaffName, UseLogID: log.ID, Content: log.Remark})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return time.Time(events[i].CreatedAt).Before(time.Time(events[j].CreatedAt))
+	})
+	return &events, nil
+}
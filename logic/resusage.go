@@ -2,7 +2,11 @@ package logic
 
 import (
 	"math"
+	"strings"
+	"time"
 
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"zone.com/common"
 )
 
@@ -20,7 +24,18 @@ type UseLog struct {
 	ReturnStaffID   uint      `json:"returnStaffId"`
 	ReturnStaffName string    `json:"returnStaffName" gorm:"size:64"`   // 归还人姓名
 	ReturnTime      *JSONTime `json:"returnTime" gorm:"type:timestamp"` // 归还时间
+	TakeCabinetID   uint      `json:"takeCabinetId"`                    // 借出智能柜
+	TakeGridNo      uint      `json:"takeGridNo"`                       // 借出箱格
+	ReturnCabinetID uint      `json:"returnCabinetId"`                  // 归还智能柜，借出时为空则放回原箱格
+	ReturnGridNo    uint      `json:"returnGridNo"`                     // 归还箱格
+	UseMinutes      int64     `json:"useMinutes"`                       // 使用时长（分钟）
+	LiftLoad        uint      `json:"liftLoad"`                         // 归还时申报的起吊载荷（吨）
+	LiftCount       uint      `json:"liftCount"`                        // 归还时申报的起吊次数
 	Remark          string    `json:"remark"`                           // 说明
+	Override        bool      `json:"override" gorm:"-"`                // 超出借用限额时强制放行，仅管理员可用
+	QRCode          string    `json:"qrCode" gorm:"-"`                  // 标签二维码内容，RFID标签丢失时代替resId
+	Offline         bool      `json:"-" gorm:"-"`                       // 智能柜离线时已放行，补传时借出检查不通过只记录在说明中
+	OperatorID      uint      `json:"-" gorm:"-"`                       // 操作用户
 }
 
 // TableName UseLog
@@ -29,104 +44,359 @@ func (UseLog) TableName() string {
 }
 
 type UseLogQueryParam struct {
-	ResName       string
-	ReturnFlag    int
-	TakeStaff     uint
-	ReturnStaff   uint
-	TakeStartTime string
-	TakeEndTime   string
+	ResName       string `json:"resName"`
+	ReturnFlag    int    `json:"returnFlag"`
+	TakeStaff     uint   `json:"takeStaff"`
+	ReturnStaff   uint   `json:"returnStaff"`
+	TakeStartTime string `json:"takeStartTime"`
+	TakeEndTime   string `json:"takeEndTime"`
 }
 
 // Store 存
 func (lgc *Logics) Store(cabinetID uint, gridNo uint, resID uint) error {
+	// 智能柜
+	cabinet, err := lgc.QueryCabinetByID(cabinetID)
+	if err != nil {
+		return common.ErrNotFound
+	}
+	if gridNo == 0 || gridNo > cabinet.GridCount {
+		return common.ErrGridNotFound
+	}
+	// 事务
+	tx := lgc.db.Begin()
 	// 判重
 	var cabinetGrid CabinetGrid
-	lgc.db.Model(&CabinetGrid{}).Where("cabinet_id = ? and grid_no = ?", cabinetID, gridNo).First(&cabinetGrid)
-	if cabinetGrid.InResID > 0 && cabinetGrid.InResID != resID {
+	if err := tx.Where("cabinet_id = ? AND grid_no = ?", cabinetID, gridNo).First(&cabinetGrid).Error; err != nil {
+		tx.Rollback()
+		return common.ErrGridNotFound
+	}
+	if cabinetGrid.InResID == resID {
+		tx.Rollback()
+		return nil
+	}
+	if cabinetGrid.InResID > 0 {
+		tx.Rollback()
 		return common.ErrGridAlreadyInUse
 	}
-	// 事务
-	tx := lgc.db.Begin()
-	// 是否存在
+	if cabinetGrid.Status != GridStatusNormal {
+		tx.Rollback()
+		return common.ErrGridDisabled
+	}
+	// 原来的存放位置
+	isOut := uint(0)
+	event := &ResEvent{ResID: resID, EventType: EventStore, CabinetID: cabinetID, GridNo: gridNo}
 	var cabinetGrid0 CabinetGrid
 	if err0 := tx.Where("in_res_id = ?", resID).First(&cabinetGrid0).Error; err0 == nil {
-		// 更新
-		if err := tx.Model(&cabinetGrid0).Updates(CabinetGrid{CabinetID: cabinetID, GridNo: gridNo}).Error; err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		// 创建新纪录
-		if err := tx.Create(&CabinetGrid{GridNo: gridNo, CabinetID: cabinetID, InResID: resID}).Error; err != nil {
+		isOut = cabinetGrid0.IsOut
+		if err := tx.Model(&cabinetGrid0).Updates(map[string]interface{}{"InResID": 0, "IsOut": 0}).Error; err != nil {
 			tx.Rollback()
 			return err
 		}
+		event.EventType = EventMove
+		event.FromCabinetID, event.FromGridNo = cabinetGrid0.CabinetID, cabinetGrid0.GridNo
 	}
-	tx.Commit()
+	// 存入新箱格
+	if err := tx.Model(&cabinetGrid).Updates(map[string]interface{}{"InResID": resID, "IsOut": isOut}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 记录事件
+	if err := recordEvent(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	lgc.bus.Publish(TopicGridChanged, event)
 	return nil
 }
 
 // TakeReturn 取-将is_out设置为1;还-将is_out设置为0
 func (lgc *Logics) TakeReturn(cabinetID uint, gridNo uint, flag int) error {
-
-	if err := lgc.db.Model(&CabinetGrid{}).Where("cabinet_id = ? and grid_no = ?", cabinetID, gridNo).Update("is_out", flag).Error; err != nil {
+	var grid CabinetGrid
+	if err := lgc.db.Where("cabinet_id = ? and grid_no = ?", cabinetID, gridNo).First(&grid).Error; err != nil {
+		return common.ErrGridNotFound
+	}
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Model(&grid).Update("is_out", flag).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 记录事件
+	topic, event := TopicSlingReturn, &ResEvent{ResID: grid.InResID, EventType: EventReturn, CabinetID: cabinetID, GridNo: gridNo}
+	if flag == 1 {
+		topic, event.EventType = TopicSlingTake, EventTake
+	}
+	if grid.InResID > 0 {
+		if err := recordEvent(tx, event); err != nil {
+			tx.Rollback()
+			return err
+		}
+	} else {
+		topic = TopicGridChanged
+	}
+	if err := tx.Commit().Error; err != nil {
 		return err
 	}
+	if grid.InResID > 0 {
+		countTakeReturn(event)
+	}
+	lgc.bus.Publish(topic, event)
 	return nil
 }
 
 // TakeReturnByResID 按资源ID取-将is_out设置为1;还-将is_out设置为0
 func (lgc *Logics) TakeReturnByResID(useLog *UseLog) error {
-
+	if err := lgc.resolveScannedSling(useLog); err != nil {
+		return err
+	}
+	// 过期的预约
+	if err := lgc.ExpireReservations(); err != nil {
+		return err
+	}
 	// 事务
 	tx := lgc.db.Begin()
-	if err := lgc.db.Model(&CabinetGrid{}).Where("in_res_id = ?", useLog.ResID).Update("is_out", useLog.Flag).Error; err != nil {
+	event, err := takeReturnInTx(tx, useLog)
+	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	lgc.afterTakeReturn(event)
+	return nil
+}
+
+// takeReturnInTx 在事务中记录借还，返回借还事件，调用方提交后调用afterTakeReturn。
+// 过期的预约需在事务前处理，避免与事务中修改的预约互相等待
+func takeReturnInTx(tx *gorm.DB, useLog *UseLog) (*ResEvent, error) {
+	var sling Sling
+	if err := tx.Where("id = ?", useLog.ResID).First(&sling).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	// 借还时间
+	now := JSONTime(time.Now())
+	if useLog.Flag == 1 && useLog.TakeTime == nil {
+		useLog.TakeTime = &now
+	}
+	if useLog.Flag != 1 && useLog.ReturnTime == nil {
+		useLog.ReturnTime = &now
+	}
+	// 借出前检查
+	if useLog.Flag == 1 {
+		if err := checkTake(tx, useLog, &sling); err != nil {
+			if !useLog.Offline {
+				return nil, err
+			}
+			useLog.Remark = strings.TrimSpace(useLog.Remark + " [离线借出：" + err.Error() + "]")
+		}
+	}
+	// 箱格
+	origin, err := takeReturnGrid(tx, useLog)
+	if err != nil {
+		return nil, err
+	}
 	// 修改使用状态，1-在库，2-借出
 	status := 1
 	if useLog.Flag == 1 {
 		status = 2
 	}
-	if err := lgc.db.Model(&Sling{}).Where("id = ?", useLog.ResID).Update("use_status", status).Error; err != nil {
-		tx.Rollback()
-		return err
+	if err := tx.Model(&Sling{}).Where("id = ?", useLog.ResID).Update("use_status", status).Error; err != nil {
+		return nil, err
 	}
 	// 记录借出日志
-	if err := lgc.SaveTakeReturnLog(useLog); err != nil {
-		tx.Rollback()
+	if err := saveTakeReturnLog(tx, useLog); err != nil {
+		return nil, err
+	}
+	// 借出后处理
+	if useLog.Flag == 1 {
+		if err := afterTake(tx, useLog, &sling); err != nil {
+			return nil, err
+		}
+	} else {
+		// 累计磨损，只在上面关闭了未归还的借出时执行
+		if err := addSlingWear(tx, useLog); err != nil {
+			return nil, err
+		}
+	}
+	// 记录事件
+	event := &ResEvent{ResID: useLog.ResID, UseLogID: useLog.ID, Content: useLog.Remark}
+	if useLog.Flag == 1 {
+		event.EventType = EventTake
+		event.CabinetID, event.GridNo = useLog.TakeCabinetID, useLog.TakeGridNo
+		event.StaffID, event.StaffName = useLog.TakeStaffID, useLog.TakeStaffName
+	} else {
+		event.EventType = EventReturn
+		event.CabinetID, event.GridNo = useLog.ReturnCabinetID, useLog.ReturnGridNo
+		event.StaffID, event.StaffName = useLog.ReturnStaffID, useLog.ReturnStaffName
+		if origin != nil && (origin.CabinetID != useLog.ReturnCabinetID || origin.GridNo != useLog.ReturnGridNo) {
+			event.FromCabinetID, event.FromGridNo = origin.CabinetID, origin.GridNo
+		}
+	}
+	if err := recordEvent(tx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// afterTakeReturn 借还提交后计数并发布事件
+func (lgc *Logics) afterTakeReturn(event *ResEvent) {
+	countTakeReturn(event)
+	if event.EventType == EventTake {
+		lgc.bus.Publish(TopicSlingTake, event)
+	} else {
+		lgc.bus.Publish(TopicSlingReturn, event)
+	}
+}
+
+// takeReturnGrid 借出时记录借出位置；归还时放回原箱格或指定的其他箱格。返回操作前所在的箱格
+func takeReturnGrid(db *gorm.DB, useLog *UseLog) (*CabinetGrid, error) {
+	var grid CabinetGrid
+	hasGrid := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("in_res_id = ?", useLog.ResID).First(&grid).Error == nil
+	var origin *CabinetGrid
+	if hasGrid {
+		origin = &grid
+	}
+
+	if useLog.Flag == 1 { // 借出
+		if !hasGrid {
+			return nil, nil
+		}
+		useLog.TakeCabinetID, useLog.TakeGridNo = grid.CabinetID, grid.GridNo
+		return origin, db.Model(&grid).Update("is_out", 1).Error
+	}
+
+	// 放回原箱格
+	if useLog.ReturnCabinetID == 0 || useLog.ReturnGridNo == 0 ||
+		(hasGrid && grid.CabinetID == useLog.ReturnCabinetID && grid.GridNo == useLog.ReturnGridNo) {
+		if !hasGrid {
+			useLog.ReturnCabinetID, useLog.ReturnGridNo = 0, 0
+			return nil, nil
+		}
+		useLog.ReturnCabinetID, useLog.ReturnGridNo = grid.CabinetID, grid.GridNo
+		return origin, db.Model(&grid).Update("is_out", 0).Error
+	}
+
+	// 放回其他箱格
+	var cabinet Cabinet
+	if err := db.Where("id = ?", useLog.ReturnCabinetID).First(&cabinet).Error; err != nil {
+		return nil, common.ErrNotFound
+	}
+	if useLog.ReturnGridNo > cabinet.GridCount {
+		return nil, common.ErrGridNotFound
+	}
+	var target CabinetGrid
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("cabinet_id = ? AND grid_no = ?", cabinet.ID, useLog.ReturnGridNo).First(&target).Error; err != nil {
+		return nil, common.ErrGridNotFound
+	}
+	if target.InResID > 0 {
+		return nil, common.ErrGridAlreadyInUse
+	}
+	if target.Status != GridStatusNormal {
+		return nil, common.ErrGridDisabled
+	}
+	if hasGrid {
+		if err := db.Model(&grid).Updates(map[string]interface{}{"InResID": 0, "IsOut": 0}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return origin, db.Model(&target).Updates(map[string]interface{}{"InResID": useLog.ResID, "IsOut": 0}).Error
+}
+
+// checkTake 借出前检查
+func checkTake(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	// 预约
+	if err := checkReservation(db, useLog, sling); err != nil {
+		return err
+	}
+	// 借用审批
+	if err := checkBorrowApproval(db, useLog, sling); err != nil {
+		return err
+	}
+	// 资质
+	if err := checkStaffQualification(db, useLog, sling); err != nil {
+		return err
+	}
+	// 借用限额
+	if err := checkStaffLimit(db, useLog, sling); err != nil {
+		return err
+	}
+	return nil
+}
+
+// afterTake 借出后处理
+func afterTake(db *gorm.DB, useLog *UseLog, sling *Sling) error {
+	// 使用预约
+	if err := useReservation(db, useLog, sling); err != nil {
+		return err
+	}
+	// 使用借用申请
+	if err := useBorrowApproval(db, useLog, sling); err != nil {
 		return err
 	}
-	tx.Commit()
 	return nil
 }
 
 // SaveTakeReturnLog 取还日志
 func (lgc *Logics) SaveTakeReturnLog(useLog *UseLog) error {
-	if useLog.Flag == 1 { // 借出，直接入库
-		if err := lgc.db.Create(&useLog).Error; err != nil {
+	return saveTakeReturnLog(lgc.db, useLog)
+}
+
+func saveTakeReturnLog(db *gorm.DB, useLog *UseLog) error {
+	if useLog.Flag == 1 { // 借出，直接入库，归还相关字段在归还时填写
+		useLog.ReturnStaffID, useLog.ReturnStaffName, useLog.ReturnTime = 0, "", nil
+		useLog.ReturnCabinetID, useLog.ReturnGridNo = 0, 0
+		useLog.UseMinutes, useLog.LiftLoad, useLog.LiftCount = 0, 0, 0
+		if err := db.Create(&useLog).Error; err != nil {
 			return err
 		}
 	} else { // 归还，更新字段
 		var log UseLog
-		if err := lgc.db.Raw("SELECT * FROM t_res_use_log WHERE res_id = ? AND created_at = (SELECT MAX(created_at) FROM t_res_use_log WHERE res_id = ?)", useLog.ResID, useLog.ResID).
+		if err := db.Raw("SELECT * FROM t_res_use_log WHERE res_id = ? AND created_at = (SELECT MAX(created_at) FROM t_res_use_log WHERE res_id = ?) FOR UPDATE", useLog.ResID, useLog.ResID).
 			Scan(&log).Error; err != nil {
 			return err
 		}
-		if err := lgc.db.Model(&log).Updates(map[string]interface{}{"return_staff_id": useLog.ReturnStaffID, "return_staff_name": useLog.ReturnStaffName, "return_time": useLog.ReturnTime, "remark": useLog.Remark}).Error; err != nil {
-			return err
+		// 最近的借出已归还，重复归还会覆盖归还信息并重复累计磨损
+		if log.ID == 0 || log.ReturnTime != nil {
+			return common.ErrSlingNotOut
+		}
+		useLog.ID = log.ID
+		// 使用时长
+		takeTime := time.Time(log.CreatedAt)
+		if log.TakeTime != nil {
+			takeTime = time.Time(*log.TakeTime)
+		}
+		if useLog.ReturnTime != nil && time.Time(*useLog.ReturnTime).After(takeTime) {
+			useLog.UseMinutes = int64(time.Time(*useLog.ReturnTime).Sub(takeTime) / time.Minute)
+		}
+		updates := map[string]interface{}{"return_staff_id": useLog.ReturnStaffID, "return_staff_name": useLog.ReturnStaffName, "return_time": useLog.ReturnTime,
+			"return_cabinet_id": useLog.ReturnCabinetID, "return_grid_no": useLog.ReturnGridNo,
+			"use_minutes": useLog.UseMinutes, "lift_load": useLog.LiftLoad, "lift_count": useLog.LiftCount}
+		// 保留借出时的说明，如超限放行和离线借出记录，归还说明追加在后面
+		if useLog.Remark != "" {
+			updates["remark"] = strings.TrimSpace(log.Remark + " " + useLog.Remark)
+		}
+		result := db.Model(&log).Where("return_time IS NULL").Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return common.ErrSlingNotOut
 		}
 	}
 
 	return nil
 }
 
-// GetTakeReturnLog 取还日志
-func (lgc *Logics) GetTakeReturnLog(param *UseLogQueryParam, pageIndex int, pageSize int) (*SearchResult, error) {
-
+// useLogQuery 取还日志查询，列表和报表共用
+func (lgc *Logics) useLogQuery(param *UseLogQueryParam) *gorm.DB {
 	logdb := lgc.db.Table("t_res_use_log").
-		Select("id, res_name, take_staff_name, created_at, take_time, return_plan_time, return_staff_name, return_time, remark").
+		Select("id, res_name, take_staff_name, created_at, take_time, return_plan_time, return_staff_name, return_time, remark, take_cabinet_id, take_grid_no, return_cabinet_id, return_grid_no, use_minutes, lift_load, lift_count").
 		// Select("t_res_use_log.*, t_res_sling.name AS res_name, t1.name AS take_staff_name, t2.name AS return_staff_name").
 		// Joins("LEFT JOIN t_res_sling ON t_res_use_log.res_id = t_res_sling.id").
 		// Joins("LEFT JOIN t_sys_staff AS t1 ON t_res_use_log.take_staff_id = t1.id").
@@ -152,6 +422,12 @@ func (lgc *Logics) GetTakeReturnLog(param *UseLogQueryParam, pageIndex int, page
 	} else if param.ReturnFlag == 2 { // 未归还
 		logdb = logdb.Where("t_res_use_log.return_time IS NULL")
 	}
+	return logdb
+}
+
+// GetTakeReturnLog 取还日志
+func (lgc *Logics) GetTakeReturnLog(param *UseLogQueryParam, pageIndex int, pageSize int) (*SearchResult, error) {
+	logdb := lgc.useLogQuery(param)
 	if pageIndex == 0 {
 		pageIndex = 1
 	}
@@ -169,3 +445,16 @@ func (lgc *Logics) GetTakeReturnLog(param *UseLogQueryParam, pageIndex int, page
 
 	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &useLogs}, nil
 }
+
+// PublishOverdueLoans 发布在[since, until)内到达预计归还时间仍未归还的借用
+func (lgc *Logics) PublishOverdueLoans(since time.Time, until time.Time) error {
+	var logs []UseLog
+	if err := lgc.db.Where("return_time IS NULL AND return_plan_time >= ? AND return_plan_time < ?", since, until).
+		Order("return_plan_time").Find(&logs).Error; err != nil {
+		return err
+	}
+	for i := range logs {
+		lgc.bus.Publish(TopicLoanOverdue, &logs[i])
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package logic
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// recordArg 接受任意参数并记录
+type recordArg struct {
+	values *[]driver.Value
+}
+
+func (a recordArg) Match(v driver.Value) bool {
+	*a.values = append(*a.values, v)
+	return true
+}
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+func TestReturnKeepsTakeRemark(t *testing.T) {
+	db, mock := newMockDB(t)
+	override := "[超限放行：借用数量超过1，操作用户：admin]"
+	takeTime := JSONTime(time.Now().Add(-2 * time.Hour))
+
+	// 管理员强制放行的借出
+	take := &UseLog{ResID: 5, Flag: 1, TakeStaffID: 3, TakeTime: &takeTime, Remark: override}
+	mock.ExpectQuery(`INSERT INTO "t_res_use_log"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	if err := saveTakeReturnLog(db, take); err != nil {
+		t.Fatal(err)
+	}
+
+	// 带说明的归还
+	returnTime := JSONTime(time.Now())
+	ret := &UseLog{ResID: 5, ReturnStaffID: 4, ReturnTime: &returnTime, Remark: "吊带有磨损"}
+	mock.ExpectQuery(`SELECT \* FROM t_res_use_log WHERE res_id = .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "res_id", "take_time", "remark"}).AddRow(7, 5, time.Time(takeTime), override))
+	var args []driver.Value
+	record := make([]driver.Value, 11)
+	for i := range record {
+		record[i] = recordArg{&args}
+	}
+	mock.ExpectExec(`UPDATE "t_res_use_log" SET .*"remark"=`).WithArgs(record...).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := saveTakeReturnLog(db, ret); err != nil {
+		t.Fatal(err)
+	}
+	want := override + " 吊带有磨损"
+	found := false
+	for _, v := range args {
+		if s, ok := v.(string); ok && strings.Contains(s, "[超限放行") {
+			if s != want {
+				t.Errorf("remark = %q, want %q", s, want)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("remark not updated, args = %v", args)
+	}
+
+	// 不带说明的归还不修改说明
+	ret = &UseLog{ResID: 5, ReturnStaffID: 4, ReturnTime: &returnTime}
+	mock.ExpectQuery(`SELECT \* FROM t_res_use_log WHERE res_id = .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "res_id", "take_time", "remark"}).AddRow(7, 5, time.Time(takeTime), override))
+	mock.ExpectExec(`UPDATE "t_res_use_log" SET "lift_count"=\$1,"lift_load"=\$2,"return_cabinet_id"=`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := saveTakeReturnLog(db, ret); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
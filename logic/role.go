@@ -9,9 +9,12 @@ import (
 // Role 用户
 type Role struct {
 	BaseModel
-	Name   string `json:"name" gorm:"size:64"`
-	Status int16  `json:"status"` // 0-正常，1-锁定，2-删除
-	Remark string `json:"remark"`
+	Name     string `json:"name" gorm:"size:64"`
+	Status   int16  `json:"status"` // 0-正常，1-锁定，2-删除
+	Remark   string `json:"remark"`
+	Approver int16  `json:"approver"` // 借用审批人：0-否，1-是
+	// CabinetOperator 可远程操作智能柜：0-否，1-是
+	CabinetOperator int16 `json:"cabinetOperator"`
 }
 
 // TableName role表
@@ -1,8 +1,11 @@
 package logic
 
 import (
+	"fmt"
 	"math"
+	"strings"
 
+	"gorm.io/gorm"
 	"zone.com/common"
 )
 
@@ -13,15 +16,21 @@ type Sling struct {
 	Name          string `json:"name" gorm:"size:64"` // 吊索具名称
 	SlingType     uint   `json:"slingType"`
 	MaxTonnage    uint   `json:"maxTonnage"`
+	SizeClass     uint   `json:"sizeClass"` // 尺寸等级，0-不限
 	UseCount      int    `json:"useCount" gorm:"-"`
 	UseStatus     uint   `json:"useStatus"`
 	InspectStatus uint   `json:"inspectStatus"`
 	PutTime       string `json:"putTime"`
-	UsePermission string `json:"usePermission"`
+	UsePermission string `json:"usePermission"`                   // 使用权限：0-自由借用，1-借用需审批
+	WearMinutes   int64  `json:"wearMinutes" gorm:"->;default:0"` // 累计使用时长（分钟）
+	WearLifts     int64  `json:"wearLifts" gorm:"->;default:0"`   // 累计起吊次数
+	WearLoad      int64  `json:"wearLoad" gorm:"->;default:0"`    // 累计起吊载荷（吨）
+	WearFlag      int16  `json:"wearFlag" gorm:"->;-:migration"`  // 磨损状态：0-正常，1-需点检，2-需报废
 	CabinetName   string `json:"cabinetName" gorm:"-"`
 	CabinetID     uint   `json:"cabinetId" gorm:"-"`
 	GridNo        uint   `json:"gridNo" gorm:"-"`
 	IsOut         uint   `json:"isOut" gorm:"-"`
+	PhotoURL      string `json:"photoUrl" gorm:"-"` // 第一张照片的缩略图
 }
 
 // TableName Sling
@@ -29,29 +38,99 @@ func (Sling) TableName() string {
 	return "t_res_sling"
 }
 
-// ListSlings 查询吊索具
-func (lgc *Logics) ListSlings(name string, slingType uint, maxTonnage uint, useStatus uint, inspectStatus uint, pageIndex int, pageSize int) (*SearchResult, error) {
+const (
+	// UsePermissionFree 自由借用
+	UsePermissionFree = "0"
+	// UsePermissionApproval 借用需审批
+	UsePermissionApproval = "1"
+)
+
+// NeedApproval 借用是否需要审批
+func (sling *Sling) NeedApproval() bool {
+	return sling.UsePermission == UsePermissionApproval
+}
+
+// checkUsePermission 校验使用权限，空值按自由借用处理
+func (sling *Sling) checkUsePermission() error {
+	switch sling.UsePermission {
+	case "":
+		sling.UsePermission = UsePermissionFree
+	case UsePermissionFree, UsePermissionApproval:
+	default:
+		return common.ErrSlingUsePermission
+	}
+	return nil
+}
+
+// normalizeUsePermission 旧版本的使用权限为自由填写的文字，空值按自由借用处理，
+// 其他非0、1的值按借用需审批处理，原值记录在吊索具履历中
+func (lgc *Logics) normalizeUsePermission() error {
+	var slings []Sling
+	if err := lgc.db.Where("use_permission IS NULL OR use_permission NOT IN ?", []string{UsePermissionFree, UsePermissionApproval}).
+		Find(&slings).Error; err != nil {
+		return err
+	}
+	for _, v := range slings {
+		if err := lgc.db.Transaction(func(tx *gorm.DB) error {
+			permission := UsePermissionApproval
+			if strings.TrimSpace(v.UsePermission) == "" {
+				permission = UsePermissionFree
+			}
+			if err := tx.Model(&Sling{}).Where("id = ?", v.ID).Update("use_permission", permission).Error; err != nil {
+				return err
+			}
+			if permission == UsePermissionFree {
+				return nil
+			}
+			return recordEvent(tx, &ResEvent{ResID: v.ID, EventType: EventUpdate,
+				Content: fmt.Sprintf("使用权限：%s→%s（数据迁移）", v.UsePermission, permission)})
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SlingQueryParam 吊索具查询条件
+type SlingQueryParam struct {
+	Name          string `json:"name"`
+	SlingType     uint   `json:"slingType"`
+	MaxTonnage    uint   `json:"maxTonnage"`
+	UseStatus     uint   `json:"useStatus"`
+	InspectStatus uint   `json:"inspectStatus"`
+}
+
+// slingQuery 吊索具查询，列表和报表共用
+func (lgc *Logics) slingQuery(param *SlingQueryParam) *gorm.DB {
 	slingdb := lgc.db.Table("t_res_sling").
-		Select("t_res_sling.*, t_res_cabinet.name AS cabinet_name, t_res_cabinet_grid.cabinet_id AS cabinet_id, t_res_cabinet_grid.grid_no AS grid_no, t_res_cabinet_grid.is_out AS is_out, t1.use_count").
+		Select("t_res_sling.*, t_res_cabinet.name AS cabinet_name, t_res_cabinet_grid.cabinet_id AS cabinet_id, t_res_cabinet_grid.grid_no AS grid_no, t_res_cabinet_grid.is_out AS is_out, t1.use_count, " + wearFlagSQL + " AS wear_flag").
 		Joins("LEFT JOIN t_res_cabinet_grid ON t_res_cabinet_grid.in_res_id = t_res_sling.id").
 		Joins("LEFT JOIN t_res_cabinet ON t_res_cabinet_grid.cabinet_id = t_res_cabinet.id").
 		Joins("LEFT JOIN (SELECT t_res_use_log.res_id, COUNT(0) AS use_count FROM t_res_use_log GROUP BY t_res_use_log.res_id) t1 ON t1.res_id = t_res_sling.id").
+		Joins("LEFT JOIN t_res_wear_threshold AS wt ON wt.sling_type = t_res_sling.sling_type").
 		Where("t_res_sling.deleted_at IS NULL")
-	if name != "" {
-		slingdb = slingdb.Where("t_res_sling.name LIKE ?", "%"+name+"%")
+	if param.Name != "" {
+		slingdb = slingdb.Where("t_res_sling.name LIKE ?", "%"+param.Name+"%")
 	}
-	if slingType > 0 {
-		slingdb = slingdb.Where("t_res_sling.sling_type = ?", slingType)
+	if param.SlingType > 0 {
+		slingdb = slingdb.Where("t_res_sling.sling_type = ?", param.SlingType)
 	}
-	if maxTonnage > 0 {
-		slingdb = slingdb.Where("t_res_sling.max_tonnage = ?", maxTonnage)
+	if param.MaxTonnage > 0 {
+		slingdb = slingdb.Where("t_res_sling.max_tonnage = ?", param.MaxTonnage)
 	}
-	if useStatus > 0 {
-		slingdb = slingdb.Where("t_res_sling.use_status = ?", useStatus)
+	if param.UseStatus > 0 {
+		slingdb = slingdb.Where("t_res_sling.use_status = ?", param.UseStatus)
 	}
-	if inspectStatus > 0 {
-		slingdb = slingdb.Where("t_res_sling.inspect_status = ?", inspectStatus)
+	if param.InspectStatus > 0 {
+		slingdb = slingdb.Where("t_res_sling.inspect_status = ?", param.InspectStatus)
 	}
+	return slingdb
+}
+
+// ListSlings 查询吊索具
+func (lgc *Logics) ListSlings(name string, slingType uint, maxTonnage uint, useStatus uint, inspectStatus uint, pageIndex int, pageSize int) (*SearchResult, error) {
+	slingdb := lgc.slingQuery(&SlingQueryParam{Name: name, SlingType: slingType, MaxTonnage: maxTonnage,
+		UseStatus: useStatus, InspectStatus: inspectStatus})
 	if pageIndex == 0 {
 		pageIndex = 1
 	}
@@ -66,6 +145,19 @@ func (lgc *Logics) ListSlings(name string, slingType uint, maxTonnage uint, useS
 	if err := slingdb.Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&slings).Error; err != nil {
 		return nil, err
 	}
+	ids := make([]uint, len(slings))
+	for i, v := range slings {
+		ids[i] = v.ID
+	}
+	photos, err := firstPhotos(lgc.db, FileEntitySling, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range slings {
+		if photo, ok := photos[v.ID]; ok {
+			slings[i].PhotoURL = ThumbnailURL(photo, listThumbSize)
+		}
+	}
 
 	return &SearchResult{Total: rowCount, PageIndex: pageIndex, PageSize: pageSize, PageCount: pageCount, List: &slings}, nil
 }
@@ -80,6 +172,10 @@ func (lgc *Logics) AddSling(sling *Sling) error {
 	if sling.RfID == "" {
 		return common.ErrSlingRfIDIsNull
 	}
+	// 使用权限
+	if err := sling.checkUsePermission(); err != nil {
+		return err
+	}
 	// 存放位置为空
 	// if sling.CabinetID == 0 || sling.GridNo == 0 {
 	// 	return  utils.ErrSlingCabinetIsNull
@@ -92,6 +188,10 @@ func (lgc *Logics) AddSling(sling *Sling) error {
 	if err := lgc.db.Create(&sling).Error; err != nil {
 		return err
 	}
+	// 记录事件
+	if err := recordEvent(lgc.db, &ResEvent{ResID: sling.ID, EventType: EventCreate, Content: sling.Name}); err != nil {
+		return err
+	}
 	// 保存位置数据
 	// sling1, _ := s.QuerySlingByName(sling.Name, sling.RfID)
 	// _, err1 := s.Store(sling.CabinetID, sling.GridNo, sling1.ID)
@@ -112,6 +212,16 @@ func (lgc *Logics) QuerySlingByName(name, rfID string) (*Sling, error) {
 	return &sling, nil
 }
 
+// QuerySlingByID 查询吊索具
+func (lgc *Logics) QuerySlingByID(id uint) (*Sling, error) {
+	var sling Sling
+	if err := lgc.db.Where("id = ?", id).First(&sling).Error; err != nil {
+		return nil, err
+	}
+
+	return &sling, nil
+}
+
 // UpdateSling 修改吊索具
 func (lgc *Logics) UpdateSling(sling *Sling) error {
 	// 吊索具RFID不能为空
@@ -122,6 +232,10 @@ func (lgc *Logics) UpdateSling(sling *Sling) error {
 	if sling.Name == "" {
 		return common.ErrSlingNameIsNull
 	}
+	// 使用权限
+	if err := sling.checkUsePermission(); err != nil {
+		return err
+	}
 	// 存放位置为空
 	// if sling.CabinetID == 0 || sling.GridNo == 0 {
 	// 	return  utils.ErrSlingCabinetIsNull
@@ -131,12 +245,23 @@ func (lgc *Logics) UpdateSling(sling *Sling) error {
 	if sling0 != nil && sling0.ID != sling.ID {
 		return common.ErrSlingAlreadyExists
 	}
+	old, err := lgc.QuerySlingByID(sling.ID)
+	if err != nil {
+		return common.ErrNotFound
+	}
 	// 事务
 	tx := lgc.db.Begin()
 	if err := tx.Save(&sling).Error; err != nil {
 		tx.Rollback()
 		return err
 	}
+	// 记录事件
+	for _, event := range slingEvents(old, sling) {
+		if err := recordEvent(tx, event); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
 	// 保存位置数据
 	// _, err1 := s.Store(sling.CabinetID, sling.GridNo, sling.ID)
 	// if err1 != nil {
@@ -155,11 +280,62 @@ func (lgc *Logics) DeleteSling(id uint) error {
 		tx.Rollback()
 		return err
 	}
-	// 删除占用的箱格
-	if err := tx.Where("in_res_id = ?", id).Delete(&CabinetGrid{}).Error; err != nil {
+	// 释放占用的箱格，存放在箱格中时记录移出的箱格
+	event := &ResEvent{ResID: id, EventType: EventDelete}
+	var grid CabinetGrid
+	if err := tx.Where("in_res_id = ?", id).Limit(1).Find(&grid).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if grid.ID > 0 && grid.IsOut == 0 {
+		event.FromCabinetID, event.FromGridNo = grid.CabinetID, grid.GridNo
+	}
+	if err := tx.Model(&CabinetGrid{}).Where("in_res_id = ?", id).
+		Updates(map[string]interface{}{"InResID": 0, "IsOut": 0}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// 记录事件
+	if err := recordEvent(tx, event); err != nil {
 		tx.Rollback()
 		return err
 	}
 	tx.Commit()
 	return nil
 }
+
+// slingEvents 吊索具修改产生的事件
+func slingEvents(old *Sling, sling *Sling) []*ResEvent {
+	var events []*ResEvent
+	var changes []string
+	if old.Name != sling.Name {
+		changes = append(changes, fmt.Sprintf("名称：%s→%s", old.Name, sling.Name))
+	}
+	if old.RfID != sling.RfID {
+		changes = append(changes, fmt.Sprintf("RFID：%s→%s", old.RfID, sling.RfID))
+	}
+	if old.SlingType != sling.SlingType {
+		changes = append(changes, fmt.Sprintf("类型：%d→%d", old.SlingType, sling.SlingType))
+	}
+	if old.MaxTonnage != sling.MaxTonnage {
+		changes = append(changes, fmt.Sprintf("吨位：%d→%d", old.MaxTonnage, sling.MaxTonnage))
+	}
+	if old.SizeClass != sling.SizeClass {
+		changes = append(changes, fmt.Sprintf("尺寸等级：%d→%d", old.SizeClass, sling.SizeClass))
+	}
+	if old.UsePermission != sling.UsePermission {
+		changes = append(changes, fmt.Sprintf("使用权限：%s→%s", old.UsePermission, sling.UsePermission))
+	}
+	if len(changes) > 0 {
+		events = append(events, &ResEvent{ResID: sling.ID, EventType: EventUpdate, Content: strings.Join(changes, "，")})
+	}
+	if old.InspectStatus != sling.InspectStatus {
+		events = append(events, &ResEvent{ResID: sling.ID, EventType: EventInspect,
+			Content: fmt.Sprintf("%d→%d", old.InspectStatus, sling.InspectStatus)})
+	}
+	if old.UseStatus != sling.UseStatus {
+		events = append(events, &ResEvent{ResID: sling.ID, EventType: EventStatus,
+			Content: fmt.Sprintf("%d→%d", old.UseStatus, sling.UseStatus)})
+	}
+	return events
+}
@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"zone.com/util"
+)
+
+const (
+	// StorageLocal 本地目录
+	StorageLocal = "local"
+	// StorageS3 S3兼容的对象存储
+	StorageS3 = "s3"
+	// StorageFileDir 旧版本存放在静态文件目录下的文件，只作为迁移来源
+	StorageFileDir = "filedir"
+)
+
+// Storage 文件内容存储
+type Storage interface {
+	// Put 保存内容
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 读取内容，支持Seek以便分段下载
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Exists 内容是否存在
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete 删除内容
+	Delete(ctx context.Context, key string) error
+	// URL 直接下载地址，不支持时返回空，由服务转发
+	URL(ctx context.Context, key string, filename string, contentType string) (string, error)
+}
+
+// NewStorage 按配置创建存储
+func NewStorage(storageType string) (Storage, error) {
+	switch storageType {
+	case "", StorageLocal:
+		return &localStorage{root: util.StorageDir}, nil
+	case StorageS3:
+		return newS3Storage()
+	case StorageFileDir:
+		return &localStorage{root: util.FileDir}, nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q", storageType)
+}
+
+// InitStorage 按配置初始化文件存储和上传策略
+func (lgc *Logics) InitStorage() error {
+	if err := loadUploadPolicies(util.UploadPolicyFile); err != nil {
+		return err
+	}
+	store, err := NewStorage(util.StorageType)
+	if err != nil {
+		return err
+	}
+	lgc.store = store
+	return nil
+}
+
+// localStorage 本地目录存储，多副本部署时需共享目录
+type localStorage struct {
+	root string
+}
+
+func (s *localStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	dest := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再改名，避免读到写了一半的内容
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (s *localStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *localStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *localStorage) URL(ctx context.Context, key string, filename string, contentType string) (string, error) {
+	return "", nil
+}
+
+// s3Storage S3兼容的对象存储，如MinIO
+type s3Storage struct {
+	client  *minio.Client
+	bucket  string
+	presign bool
+}
+
+// presignExpiry 预签名下载地址有效期
+const presignExpiry = 15 * time.Minute
+
+func newS3Storage() (*s3Storage, error) {
+	client, err := minio.New(util.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(util.S3AccessKey, util.S3SecretKey, ""),
+		Secure: util.S3UseSSL,
+		Region: util.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 存储桶不存在时创建
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, util.S3Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, util.S3Bucket, minio.MakeBucketOptions{Region: util.S3Region}); err != nil {
+			return nil, err
+		}
+	}
+	return &s3Storage{client: client, bucket: util.S3Bucket, presign: util.S3Presign}, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+func (s *s3Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject不会立即请求，先取对象信息以便返回不存在等错误
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *s3Storage) URL(ctx context.Context, key string, filename string, contentType string) (string, error) {
+	if !s.presign {
+		return "", nil
+	}
+	params := url.Values{}
+	params.Set("response-content-type", contentType)
+	params.Set("response-content-disposition", fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(filename)))
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, presignExpiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// MigrateStorage 把文件内容从from存储复制到当前配置的存储，已存在的跳过，返回复制和跳过的数量
+func (lgc *Logics) MigrateStorage(from Storage) (copied int, skipped int, err error) {
+	var hashes []string
+	if err := lgc.db.Model(&FileObject{}).Distinct("hash").Pluck("hash", &hashes).Error; err != nil {
+		return 0, 0, err
+	}
+	ctx := context.Background()
+	for _, hash := range hashes {
+		key := blobKey(hash)
+		exists, err := lgc.store.Exists(ctx, key)
+		if err != nil {
+			return copied, skipped, err
+		}
+		if exists {
+			skipped++
+			continue
+		}
+		if err := copyBlob(ctx, from, lgc.store, key); err != nil {
+			return copied, skipped, fmt.Errorf("%s: %v", hash, err)
+		}
+		copied++
+	}
+	return copied, skipped, nil
+}
+
+func copyBlob(ctx context.Context, from Storage, to Storage, key string) error {
+	src, err := from.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	size, err := src.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return to.Put(ctx, key, src, size, "application/octet-stream")
+}
@@ -0,0 +1,258 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"zone.com/common"
+	"zone.com/util"
+)
+
+// ReportSubscription 定时报表订阅
+type ReportSubscription struct {
+	BaseModel
+	Name        string    `json:"name" gorm:"size:64"`             // 订阅名称
+	ReportTypes string    `json:"reportTypes"`                     // 报表类型，多个用逗号分隔：use_logs、overdue、slings、inspection
+	Format      string    `json:"format" gorm:"size:8"`            // 报表格式：xlsx、pdf
+	Filters     string    `json:"filters"`                         // 查询条件JSON，字段同报表接口的查询参数
+	Days        int       `json:"days"`                            // 取还记录只统计最近的天数，0-不限
+	Cron        string    `json:"cron" gorm:"size:64"`             // 发送时间，标准cron表达式，如每周一8点"0 8 * * 1"
+	Channel     string    `json:"channel" gorm:"size:16"`          // 发送方式：email、webhook
+	Recipients  string    `json:"recipients"`                      // 邮件收件人，多个用逗号分隔
+	WebhookURL  string    `json:"webhookUrl"`                      // Webhook地址
+	Status      int16     `json:"status"`                          // 状态：0-启用，1-停用
+	NextRunAt   *JSONTime `json:"nextRunAt" gorm:"type:timestamp"` // 下次发送时间
+	LastRunAt   *JSONTime `json:"lastRunAt" gorm:"type:timestamp"` // 上次发送时间
+	LastError   string    `json:"lastError"`                       // 上次发送失败的原因
+	Remark      string    `json:"remark"`                          // 说明
+}
+
+// TableName ReportSubscription
+func (ReportSubscription) TableName() string {
+	return "t_res_report_subscription"
+}
+
+const (
+	// ReportUseLogs 取还记录
+	ReportUseLogs = "use_logs"
+	// ReportOverdueLoans 逾期未还
+	ReportOverdueLoans = "overdue"
+	// ReportSlingInventory 吊索具库存
+	ReportSlingInventory = "slings"
+	// ReportSlingInspection 点检状态
+	ReportSlingInspection = "inspection"
+
+	// ChannelEmail 邮件
+	ChannelEmail = "email"
+	// ChannelWebhook Webhook
+	ChannelWebhook = "webhook"
+)
+
+// reportNames 报表名称
+var reportNames = map[string]string{
+	ReportUseLogs:         "吊索具取还记录",
+	ReportOverdueLoans:    "逾期未还吊索具",
+	ReportSlingInventory:  "吊索具库存",
+	ReportSlingInspection: "吊索具点检状态",
+}
+
+// ListReportSubscriptions 查询报表订阅
+func (lgc *Logics) ListReportSubscriptions() (*[]ReportSubscription, error) {
+	var subs []ReportSubscription
+	if err := lgc.db.Where("deleted_at IS NULL").Order("id").Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	return &subs, nil
+}
+
+// AddReportSubscription 添加报表订阅
+func (lgc *Logics) AddReportSubscription(sub *ReportSubscription) error {
+	if err := checkReportSubscription(sub); err != nil {
+		return err
+	}
+	sub.LastRunAt, sub.LastError = nil, ""
+	if err := lgc.db.Create(&sub).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateReportSubscription 修改报表订阅，按新的发送时间重新计算下次发送时间
+func (lgc *Logics) UpdateReportSubscription(sub *ReportSubscription) error {
+	if err := checkReportSubscription(sub); err != nil {
+		return err
+	}
+	if err := lgc.db.Model(&ReportSubscription{BaseModel: BaseModel{ID: sub.ID}}).
+		Select("name", "report_types", "format", "filters", "days", "cron", "channel", "recipients", "webhook_url", "status", "next_run_at", "remark").
+		Updates(sub).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteReportSubscription 删除报表订阅
+func (lgc *Logics) DeleteReportSubscription(id uint) error {
+	if err := lgc.db.Where("id = ?", id).Delete(&ReportSubscription{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkReportSubscription 检查订阅设置并计算下次发送时间
+func checkReportSubscription(sub *ReportSubscription) error {
+	types := strings.Split(sub.ReportTypes, ",")
+	for _, v := range types {
+		if _, ok := reportNames[strings.TrimSpace(v)]; !ok {
+			return common.ErrReportSubscription
+		}
+	}
+	if sub.Format == "" {
+		sub.Format = ReportXLSX
+	}
+	if err := CheckReportFormat(sub.Format); err != nil {
+		return err
+	}
+	if sub.Filters != "" && !json.Valid([]byte(sub.Filters)) {
+		return common.ErrReportSubscription
+	}
+	switch sub.Channel {
+	case ChannelEmail:
+		if strings.TrimSpace(sub.Recipients) == "" {
+			return common.ErrReportSubscription
+		}
+	case ChannelWebhook:
+		if err := util.CheckWebhookURL(sub.WebhookURL); err != nil {
+			return common.ErrReportSubscription
+		}
+	default:
+		return common.ErrReportSubscription
+	}
+	schedule, err := cron.ParseStandard(sub.Cron)
+	if err != nil {
+		return common.ErrReportSubscription
+	}
+	next := JSONTime(schedule.Next(time.Now()))
+	sub.NextRunAt = &next
+	return nil
+}
+
+// RunDueReportSubscriptions 发送到期的报表订阅，由定时任务调用
+func (lgc *Logics) RunDueReportSubscriptions() error {
+	var subs []ReportSubscription
+	if err := lgc.db.Where("deleted_at IS NULL AND status = 0 AND next_run_at <= ?", time.Now()).
+		Order("next_run_at").Find(&subs).Error; err != nil {
+		return err
+	}
+	for i := range subs {
+		if err := lgc.runReportSubscription(&subs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReportSubscription 立即发送报表订阅
+func (lgc *Logics) RunReportSubscription(id uint) error {
+	var sub ReportSubscription
+	if err := lgc.db.Where("id = ? AND deleted_at IS NULL", id).First(&sub).Error; err != nil {
+		return common.ErrNotFound
+	}
+	if err := lgc.runReportSubscription(&sub); err != nil {
+		return err
+	}
+	if sub.LastError != "" {
+		return errors.New(sub.LastError)
+	}
+	return nil
+}
+
+// runReportSubscription 生成并发送报表，记录发送结果和下次发送时间
+func (lgc *Logics) runReportSubscription(sub *ReportSubscription) error {
+	now := time.Now()
+	sub.LastError = ""
+	if err := lgc.deliverReports(sub, now); err != nil {
+		sub.LastError = err.Error()
+	}
+	lastRun := JSONTime(now)
+	sub.LastRunAt = &lastRun
+	updates := map[string]interface{}{"last_run_at": sub.LastRunAt, "last_error": sub.LastError}
+	if schedule, err := cron.ParseStandard(sub.Cron); err == nil {
+		next := JSONTime(schedule.Next(now))
+		updates["next_run_at"] = &next
+	} else {
+		updates["status"] = 1
+	}
+	return lgc.db.Model(&ReportSubscription{}).Where("id = ?", sub.ID).UpdateColumns(updates).Error
+}
+
+// deliverReports 生成订阅的所有报表并通过邮件或Webhook发送
+func (lgc *Logics) deliverReports(sub *ReportSubscription, now time.Time) error {
+	var useLogParam UseLogQueryParam
+	var slingParam SlingQueryParam
+	if sub.Filters != "" {
+		if err := json.Unmarshal([]byte(sub.Filters), &useLogParam); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(sub.Filters), &slingParam); err != nil {
+			return err
+		}
+	}
+	if sub.Days > 0 {
+		useLogParam.TakeStartTime = now.AddDate(0, 0, -sub.Days).Format(localDateTimeFormat)
+		useLogParam.TakeEndTime = now.Format(localDateTimeFormat)
+	}
+
+	var attachments []util.Attachment
+	var names []string
+	for _, reportType := range strings.Split(sub.ReportTypes, ",") {
+		reportType = strings.TrimSpace(reportType)
+		var buf bytes.Buffer
+		if err := lgc.WriteReport(&buf, reportType, sub.Format, &useLogParam, &slingParam); err != nil {
+			return err
+		}
+		attachments = append(attachments, util.Attachment{
+			Filename:    fmt.Sprintf("%s_%s.%s", reportNames[reportType], now.Format("20060102"), sub.Format),
+			ContentType: ReportContentTypes[sub.Format],
+			Data:        buf.Bytes(),
+		})
+		names = append(names, reportNames[reportType])
+	}
+
+	subject := fmt.Sprintf("%s（%s）", sub.Name, now.Format("2006-01-02"))
+	if sub.Channel == ChannelWebhook {
+		return util.PostWebhook(sub.WebhookURL, map[string]string{
+			"subscription": sub.Name,
+			"reportTypes":  sub.ReportTypes,
+			"time":         now.Format(localDateTimeFormat),
+		}, attachments)
+	}
+	var recipients []string
+	for _, v := range strings.Split(sub.Recipients, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			recipients = append(recipients, v)
+		}
+	}
+	body := fmt.Sprintf("附件为%s生成的报表：%s。", now.Format(localDateTimeFormat), strings.Join(names, "、"))
+	return util.SendMail(recipients, subject, body, attachments)
+}
+
+// WriteReport 按报表类型生成报表
+func (lgc *Logics) WriteReport(w io.Writer, reportType string, format string, useLogParam *UseLogQueryParam, slingParam *SlingQueryParam) error {
+	switch reportType {
+	case ReportUseLogs:
+		return lgc.ReportUseLogs(w, format, useLogParam)
+	case ReportOverdueLoans:
+		return lgc.ReportOverdue(w, format, useLogParam)
+	case ReportSlingInventory:
+		return lgc.ReportSlings(w, format, slingParam)
+	case ReportSlingInspection:
+		return lgc.ReportInspection(w, format, slingParam)
+	}
+	return common.ErrBadQueryParams
+}
@@ -1,13 +1,9 @@
 package logic
 
 import (
-	"fmt"
-	"io"
 	"math"
-	"os"
 
 	"zone.com/common"
-	"zone.com/util"
 )
 
 // Company 公司
@@ -25,12 +21,13 @@ func (Company) TableName() string {
 
 // Department 部门
 type Department struct {
-	ID        uint    `json:"id" gorm:"primary_key"`
-	Name      string  `json:"name" gorm:"size:128"`                // 部门名称
-	Company   Company `json:"company" gorm:"ForeignKey:CompanyID"` // 公司
-	CompanyID uint    `json:"companyId"`                           // 公司ID
-	Status    int16   `json:"status"`                              // 状态：0-正常，1-停用
-	Remark    string  `json:"remark"`                              // 说明
+	ID          uint    `json:"id" gorm:"primary_key"`
+	Name        string  `json:"name" gorm:"size:128"`                // 部门名称
+	Company     Company `json:"company" gorm:"ForeignKey:CompanyID"` // 公司
+	CompanyID   uint    `json:"companyId"`                           // 公司ID
+	HeadStaffID uint    `json:"headStaffId"`                         // 部门负责人
+	Status      int16   `json:"status"`                              // 状态：0-正常，1-停用
+	Remark      string  `json:"remark"`                              // 说明
 }
 
 // TableName department
@@ -49,6 +46,7 @@ type Staff struct {
 	PostName       string    `json:"postName"`                       // 职务
 	Birthday       *JSONTime `json:"birthday" gorm:"type:timestamp"` // 出生日期
 	Status         int16     `json:"status"`                         // 状态：0-正常
+	PhotoID        uint      `json:"photoId"`                        // 照片文件
 	Remark         string    `json:"remark"`                         // 说明
 }
 
@@ -130,61 +128,29 @@ func (lgc *Logics) ListDepartments(name string, companyID uint, pageIndex int, p
 }
 
 // AddStaff 添加员工
-func (lgc *Logics) AddStaff(staff *Staff) error {
+func (lgc *Logics) AddStaff(staff *Staff, userID uint) error {
 	// 员工姓名不能为空
 	if staff.Name == "" {
 		return common.ErrStaffNameIsNull
 	}
 
-	if err := lgc.db.Create(&staff).Error; err != nil {
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Create(&staff).Error; err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	// 员工的照片
-	srcFile := "./temp/temp.jpg"
-	srcDefault := "./temp/default.jpg"
-	fileName := fmt.Sprintf("./temp/%06d.jpg", staff.ID)
-	if fileExist(srcFile) {
-		os.Rename(srcFile, fileName)
-		copy(fileName, fmt.Sprintf("%s%06d.jpg", util.FileDir, staff.ID))
-		// 覆盖掉web目录的temp.jpg
-		copy(srcDefault, fmt.Sprintf("%stemp.jpg", util.FileDir))
-	}
-	return nil
-}
-
-func fileExist(path string) bool {
-	_, err := os.Lstat(path)
-	return !os.IsNotExist(err)
-}
-
-func copy(src, dst string) (int64, error) {
-	sourceFileStat, err := os.Stat(src)
-	if err != nil {
-		return 0, err
-	}
-
-	if !sourceFileStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", src)
-	}
-
-	source, err := os.Open(src)
-	if err != nil {
-		return 0, err
-	}
-	defer source.Close()
-
-	destination, err := os.Create(dst)
-	if err != nil {
-		return 0, err
+	if err := linkFile(tx, staff.PhotoID, FileEntityStaff, staff.ID, userID); err != nil {
+		tx.Rollback()
+		return err
 	}
-	defer destination.Close()
-	nBytes, err := io.Copy(destination, source)
-	return nBytes, err
+	return tx.Commit().Error
 }
 
 // UpdateStaff 修改员工
-func (lgc *Logics) UpdateStaff(staff *Staff) error {
+func (lgc *Logics) UpdateStaff(staff *Staff, userID uint) error {
 	// 默认员工不准修改
 	if staff.ID == 1 {
 		return common.ErrNoUpdate
@@ -194,10 +160,18 @@ func (lgc *Logics) UpdateStaff(staff *Staff) error {
 	if staff.Name == "" {
 		return common.ErrStaffNameIsNull
 	}
-	if err := lgc.db.Save(&staff).Error; err != nil {
+	// 事务
+	tx := lgc.db.Begin()
+	if err := tx.Save(&staff).Error; err != nil {
+		tx.Rollback()
 		return err
 	}
-	return nil
+	// 员工的照片
+	if err := linkFile(tx, staff.PhotoID, FileEntityStaff, staff.ID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
 // DeleteStaff 删除员工
@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"zone.com/common"
+)
+
+// ThumbnailSizes 可用的缩略图尺寸（最长边像素）
+var ThumbnailSizes = map[int]bool{64: true, 128: true, 256: true, 512: true}
+
+const (
+	// maxThumbnailSide 可生成缩略图的最大边长，上传策略不限制尺寸时使用
+	maxThumbnailSide = 8000
+	// maxThumbnailPixels 可生成缩略图的最大像素数，解码后约占64MB
+	maxThumbnailPixels = 4096 * 4096
+	// maxThumbnailJobs 同时生成缩略图的数量，限制解码图片占用的内存
+	maxThumbnailJobs = 2
+	// thumbnailWait 等待生成缩略图的最长时间
+	thumbnailWait = 10 * time.Second
+)
+
+// thumbnailJobs 生成缩略图的信号量
+var thumbnailJobs = make(chan struct{}, maxThumbnailJobs)
+
+// thumbnailTypes 可生成缩略图的图片类型
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// thumbnailKey 缩略图在存储中的位置，按内容hash缓存，相同图片共用
+func thumbnailKey(hash string, size int) string {
+	return path.Join("thumbs", hash[:2], fmt.Sprintf("%s_%d.jpg", hash, size))
+}
+
+// thumbnail 取缩略图，没有缓存时生成并保存。排队超过thumbnailWait或ctx结束时不再等待
+func (lgc *Logics) thumbnail(ctx context.Context, file *FileObject, size int) (string, error) {
+	if !ThumbnailSizes[size] {
+		return "", common.ErrBadQueryParams
+	}
+	if !thumbnailTypes[file.MimeType] {
+		return "", common.ErrFileNotImage
+	}
+	key := thumbnailKey(file.Hash, size)
+	exists, err := lgc.store.Exists(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return key, nil
+	}
+	wait := time.NewTimer(thumbnailWait)
+	defer wait.Stop()
+	select {
+	case thumbnailJobs <- struct{}{}:
+	case <-wait.C:
+		return "", common.ErrThumbnailBusy
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-thumbnailJobs }()
+	// 等待期间可能已由其他请求生成
+	if exists, err := lgc.store.Exists(ctx, key); err != nil || exists {
+		return key, err
+	}
+
+	src, err := lgc.store.Open(ctx, blobKey(file.Hash))
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	// 先读取尺寸，避免解码过大的图片
+	config, _, err := image.DecodeConfig(src)
+	if err != nil {
+		return "", common.ErrFileNotImage
+	}
+	maxWidth, maxHeight := thumbnailLimit(file.EntityType)
+	if config.Width > maxWidth || config.Height > maxHeight || config.Width*config.Height > maxThumbnailPixels {
+		return "", common.ErrImageTooLarge
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return "", common.ErrFileNotImage
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizeImage(img, size), &jpeg.Options{Quality: 85}); err != nil {
+		return "", err
+	}
+	if err := lgc.store.Put(ctx, key, &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// thumbnailLimit 可生成缩略图的最大宽高，按上传策略，不超过maxThumbnailSide
+func thumbnailLimit(entityType string) (int, int) {
+	policy := GetUploadPolicy(entityType)
+	width, height := policy.MaxWidth, policy.MaxHeight
+	if width <= 0 || width > maxThumbnailSide {
+		width = maxThumbnailSide
+	}
+	if height <= 0 || height > maxThumbnailSide {
+		height = maxThumbnailSide
+	}
+	return width, height
+}
+
+// resizeImage 按比例缩小到最长边不超过size，透明部分填充白色
+func resizeImage(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
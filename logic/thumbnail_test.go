@@ -0,0 +1,62 @@
+package logic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+
+	"zone.com/common"
+)
+
+func putTestBlob(t *testing.T, lgc *Logics, hash string, data []byte) {
+	t.Helper()
+	if err := lgc.store.Put(context.Background(), blobKey(hash), bytes.NewReader(data), int64(len(data)), "image/gif"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThumbnailPixelLimit(t *testing.T) {
+	lgc := &Logics{store: &localStorage{root: t.TempDir()}}
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), []color.Color{color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+	// 宽高在上传策略以内，像素数超过限制
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data[6:], 5000)
+	binary.LittleEndian.PutUint16(data[8:], 4000)
+	hash := "aa00000000000000000000000000000000000000000000000000000000000000"
+	putTestBlob(t, lgc, hash, data)
+	file := &FileObject{Hash: hash, MimeType: "image/gif", EntityType: FileEntitySling}
+	if _, err := lgc.thumbnail(context.Background(), file, 128); err != common.ErrImageTooLarge {
+		t.Fatalf("err = %v, want ErrImageTooLarge", err)
+	}
+}
+
+func TestThumbnailQueue(t *testing.T) {
+	lgc := &Logics{store: &localStorage{root: t.TempDir()}}
+	file := &FileObject{Hash: "bb00000000000000000000000000000000000000000000000000000000000000", MimeType: "image/gif"}
+	for i := 0; i < maxThumbnailJobs; i++ {
+		thumbnailJobs <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < maxThumbnailJobs; i++ {
+			<-thumbnailJobs
+		}
+	}()
+	// 请求结束时不再排队
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := lgc.thumbnail(ctx, file, 128); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %v", elapsed)
+	}
+}
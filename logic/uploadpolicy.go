@@ -0,0 +1,101 @@
+package logic
+
+import (
+	"encoding/json"
+	"image"
+	"io"
+	"mime"
+	"os"
+
+	"zone.com/common"
+)
+
+// UploadPolicy 上传策略，按用途（关联对象类型）设置
+type UploadPolicy struct {
+	Types     []string `json:"types"`     // 允许的类型，按内容识别
+	MaxSize   int64    `json:"maxSize"`   // 最大字节数
+	MaxWidth  int      `json:"maxWidth"`  // 图片最大宽度，0-不限
+	MaxHeight int      `json:"maxHeight"` // 图片最大高度，0-不限
+	StripMeta bool     `json:"stripMeta"` // 去除图片的EXIF等元数据
+}
+
+const mb = 1 << 20
+
+var (
+	imageTypes    = []string{"image/jpeg", "image/png", "image/webp"}
+	documentTypes = []string{"image/jpeg", "image/png", "image/webp", "application/pdf"}
+)
+
+// uploadPolicies 各用途的上传策略，未关联对象的文件使用空用途的策略
+var uploadPolicies = map[string]*UploadPolicy{
+	"":                   {Types: documentTypes, MaxSize: 10 * mb, MaxWidth: 8000, MaxHeight: 8000, StripMeta: true},
+	FileEntityStaff:      {Types: imageTypes, MaxSize: 5 * mb, MaxWidth: 4096, MaxHeight: 4096, StripMeta: true},
+	FileEntityStaffCert:  {Types: documentTypes, MaxSize: 10 * mb, MaxWidth: 8000, MaxHeight: 8000, StripMeta: true},
+	FileEntitySling:      {Types: documentTypes, MaxSize: 20 * mb, MaxWidth: 8000, MaxHeight: 8000, StripMeta: true},
+	FileEntityCabinet:    {Types: documentTypes, MaxSize: 20 * mb, MaxWidth: 8000, MaxHeight: 8000, StripMeta: true},
+	FileEntityInspection: {Types: documentTypes, MaxSize: 20 * mb, MaxWidth: 8000, MaxHeight: 8000, StripMeta: true},
+}
+
+// loadUploadPolicies 从JSON文件读取上传策略，按用途覆盖默认策略
+func loadUploadPolicies(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	policies := map[string]*UploadPolicy{}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return err
+	}
+	for purpose, policy := range policies {
+		if purpose != "" && !fileEntities[purpose] {
+			return common.ErrBadQueryParams
+		}
+		uploadPolicies[purpose] = policy
+	}
+	return nil
+}
+
+// GetUploadPolicy 取用途的上传策略
+func GetUploadPolicy(purpose string) *UploadPolicy {
+	if policy, ok := uploadPolicies[purpose]; ok {
+		return policy
+	}
+	return uploadPolicies[""]
+}
+
+// MaxUploadSize 所有策略中最大的文件大小，用于在读取前限制请求体
+func MaxUploadSize() int64 {
+	max := int64(0)
+	for _, policy := range uploadPolicies {
+		if policy.MaxSize > max {
+			max = policy.MaxSize
+		}
+	}
+	return max
+}
+
+// checkType 检查按内容识别的类型
+func (p *UploadPolicy) checkType(mimeType string) error {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	for _, t := range p.Types {
+		if t == mediaType {
+			return nil
+		}
+	}
+	return common.ErrFileType
+}
+
+// checkImage 检查图片尺寸，只读取图片头
+func (p *UploadPolicy) checkImage(r io.Reader) error {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return common.ErrFileNotImage
+	}
+	if (p.MaxWidth > 0 && config.Width > p.MaxWidth) || (p.MaxHeight > 0 && config.Height > p.MaxHeight) {
+		return common.ErrImageTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package logic
+
+import (
+	"gorm.io/gorm"
+	"zone.com/common"
+)
+
+// WearThreshold 吊索具磨损阈值
+type WearThreshold struct {
+	ID             uint   `json:"id" gorm:"primary_key"`
+	SlingType      uint   `json:"slingType"`      // 吊索具类型
+	InspectMinutes int64  `json:"inspectMinutes"` // 累计使用时长达到该值需点检，0-不限
+	InspectLifts   int64  `json:"inspectLifts"`   // 累计起吊次数达到该值需点检，0-不限
+	InspectLoad    int64  `json:"inspectLoad"`    // 累计起吊载荷达到该值需点检，0-不限
+	RetireMinutes  int64  `json:"retireMinutes"`  // 累计使用时长达到该值需报废，0-不限
+	RetireLifts    int64  `json:"retireLifts"`    // 累计起吊次数达到该值需报废，0-不限
+	RetireLoad     int64  `json:"retireLoad"`     // 累计起吊载荷达到该值需报废，0-不限
+	Remark         string `json:"remark"`         // 说明
+}
+
+// TableName WearThreshold
+func (WearThreshold) TableName() string {
+	return "t_res_wear_threshold"
+}
+
+const (
+	// WearNormal 正常
+	WearNormal int16 = 0
+	// WearInspect 需点检
+	WearInspect int16 = 1
+	// WearRetire 需报废
+	WearRetire int16 = 2
+)
+
+// wearFlagSQL 按磨损阈值计算吊索具磨损状态，需关联别名为wt的磨损阈值表
+const wearFlagSQL = `CASE
+	WHEN (wt.retire_minutes > 0 AND t_res_sling.wear_minutes >= wt.retire_minutes)
+		OR (wt.retire_lifts > 0 AND t_res_sling.wear_lifts >= wt.retire_lifts)
+		OR (wt.retire_load > 0 AND t_res_sling.wear_load >= wt.retire_load) THEN 2
+	WHEN (wt.inspect_minutes > 0 AND t_res_sling.wear_minutes >= wt.inspect_minutes)
+		OR (wt.inspect_lifts > 0 AND t_res_sling.wear_lifts >= wt.inspect_lifts)
+		OR (wt.inspect_load > 0 AND t_res_sling.wear_load >= wt.inspect_load) THEN 1
+	ELSE 0 END`
+
+// ListWearThresholds 查询磨损阈值
+func (lgc *Logics) ListWearThresholds() (*[]WearThreshold, error) {
+	var thresholds []WearThreshold
+	if err := lgc.db.Order("sling_type").Find(&thresholds).Error; err != nil {
+		return nil, err
+	}
+	return &thresholds, nil
+}
+
+// AddWearThreshold 添加磨损阈值
+func (lgc *Logics) AddWearThreshold(threshold *WearThreshold) error {
+	if err := lgc.checkWearThreshold(threshold); err != nil {
+		return err
+	}
+	if err := lgc.db.Create(&threshold).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateWearThreshold 修改磨损阈值
+func (lgc *Logics) UpdateWearThreshold(threshold *WearThreshold) error {
+	if err := lgc.checkWearThreshold(threshold); err != nil {
+		return err
+	}
+	if err := lgc.db.Save(&threshold).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteWearThreshold 删除磨损阈值
+func (lgc *Logics) DeleteWearThreshold(id uint) error {
+	if err := lgc.db.Where("id = ?", id).Delete(&WearThreshold{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (lgc *Logics) checkWearThreshold(threshold *WearThreshold) error {
+	if threshold.SlingType == 0 {
+		return common.ErrBadQueryParams
+	}
+	// 每种类型只能有一条阈值
+	var count int64
+	if err := lgc.db.Model(&WearThreshold{}).
+		Where("sling_type = ? AND id <> ?", threshold.SlingType, threshold.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return common.ErrAlreadyExists
+	}
+	return nil
+}
+
+// ListWearAlerts 查询需点检或报废的吊索具
+func (lgc *Logics) ListWearAlerts() (*[]Sling, error) {
+	var slings []Sling
+	if err := lgc.db.Table("t_res_sling").
+		Select("t_res_sling.*, " + wearFlagSQL + " AS wear_flag").
+		Joins("JOIN t_res_wear_threshold AS wt ON wt.sling_type = t_res_sling.sling_type").
+		Where("t_res_sling.deleted_at IS NULL AND " + wearFlagSQL + " > 0").
+		Order("wear_flag DESC, t_res_sling.wear_minutes DESC").
+		Find(&slings).Error; err != nil {
+		return nil, err
+	}
+	return &slings, nil
+}
+
+// addSlingWear 归还时累计吊索具的使用时长、起吊次数和载荷
+func addSlingWear(db *gorm.DB, useLog *UseLog) error {
+	load := int64(useLog.LiftLoad)
+	if useLog.LiftCount > 1 {
+		load *= int64(useLog.LiftCount)
+	}
+	return db.Exec("UPDATE t_res_sling SET wear_minutes = wear_minutes + ?, wear_lifts = wear_lifts + ?, wear_load = wear_load + ? WHERE id = ?",
+		useLog.UseMinutes, useLog.LiftCount, load, useLog.ResID).Error
+}
@@ -17,6 +17,17 @@ func main() {
 	op.AddFlags(pflag.CommandLine)
 	pflag.Parse()
 
+	// 迁移上传文件存储
+	if op.MigrateStorage != "" {
+		copied, skipped, err := app.MigrateStorage(op)
+		fmt.Fprintf(os.Stderr, "storage migrated from %s: %d copied, %d skipped\n", op.MigrateStorage, copied, skipped)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate storage failed, %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 启动服务
 	if err := app.Run(op); err != nil {
 		fmt.Fprintf(os.Stderr, "run app failed, %v\n", err)
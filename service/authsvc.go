@@ -1,6 +1,7 @@
 package service
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -42,10 +43,12 @@ func (s *service) login(c echo.Context) error {
 
 	user, err := s.lgc.QueryUserByName(u.Name)
 	if err != nil {
+		loginFailures.WithLabelValues("user").Inc()
 		return err
 	}
 	// 用户状态异常
 	if err := user.Check(); err != nil {
+		loginFailures.WithLabelValues("status").Inc()
 		return err
 	}
 
@@ -53,6 +56,7 @@ func (s *service) login(c echo.Context) error {
 
 	// Throws unauthorized error
 	if user.Name != u.Name || user.Password != passwordNew {
+		loginFailures.WithLabelValues("password").Inc()
 		return common.ErrUserPwdDismatch
 	}
 
@@ -66,6 +70,20 @@ func (s *service) login(c echo.Context) error {
 	}))
 }
 
+// currentUserID 当前登录用户
+func currentUserID(c echo.Context) uint {
+	user, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0
+	}
+	claims, ok := user.Claims.(*jwtCustomClaims)
+	if !ok {
+		return 0
+	}
+	userId, _ := strconv.Atoi(claims.UserId)
+	return uint(userId)
+}
+
 func (s *service) signToken(user *logic.User) (string, error) {
 	// Set custom claims
 	claims := &jwtCustomClaims{
@@ -89,6 +107,92 @@ func (s *service) signToken(user *logic.User) (string, error) {
 	return t, nil
 }
 
+const (
+	// ticketLive 实时推送
+	ticketLive = "live"
+	// ticketLabel 标签
+	ticketLabel = "label"
+	// ticketExpiry 票据有效期，只用于建立连接或打开链接
+	ticketExpiry = time.Minute
+)
+
+var ticketPurposes = map[string]bool{ticketLive: true, ticketLabel: true}
+
+// ticketClaims 短期票据，Audience为用途，SessionExpiresAt为登录令牌的过期时间
+type ticketClaims struct {
+	UserId           string `json:"userId"`
+	Name             string `json:"name"`
+	Admin            bool   `json:"admin"`
+	SessionExpiresAt int64  `json:"sessionExp"`
+	jwt.StandardClaims
+}
+
+// ticketKey 票据使用单独的密钥，不能当作登录令牌使用
+func ticketKey() []byte {
+	mac := hmac.New(sha256.New, util.SecretKey)
+	mac.Write([]byte("ticket"))
+	return mac.Sum(nil)
+}
+
+// issueTicket EventSource和标签链接无法设置请求头，签发指定用途的短期票据放在ticket查询参数中
+func (s *service) issueTicket(c echo.Context) error {
+	purpose := c.QueryParam("purpose")
+	if !ticketPurposes[purpose] {
+		return common.ErrBadQueryParams
+	}
+	claims := c.Get("user").(*jwt.Token).Claims.(*jwtCustomClaims)
+	expiresAt := time.Now().Add(ticketExpiry).Unix()
+	ticket := &ticketClaims{claims.UserId, claims.Name, claims.Admin, claims.ExpiresAt,
+		jwt.StandardClaims{Audience: purpose, ExpiresAt: expiresAt}}
+	t, err := jwt.NewWithClaims(jwt.SigningMethodHS256, ticket).SignedString(ticketKey())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(echo.Map{
+		"ticket":    t,
+		"expiresAt": expiresAt,
+	}))
+}
+
+// ticketOrJWT 有ticket查询参数时验证票据用途，否则按Authorization请求头验证令牌
+func (s *service) ticketOrJWT(purpose string) echo.MiddlewareFunc {
+	jwtAuth := middleware.JWTWithConfig(*s.jwtConfig)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJWT := jwtAuth(next)
+		return func(c echo.Context) error {
+			t := c.QueryParam("ticket")
+			if t == "" {
+				return withJWT(c)
+			}
+			ticket := &ticketClaims{}
+			token, err := jwt.ParseWithClaims(t, ticket, func(token *jwt.Token) (interface{}, error) {
+				if token.Method != jwt.SigningMethodHS256 {
+					return nil, middleware.ErrJWTInvalid
+				}
+				return ticketKey(), nil
+			})
+			if err != nil || !token.Valid || !ticket.VerifyAudience(purpose, true) {
+				return middleware.ErrJWTInvalid
+			}
+			// 按登录令牌设置当前用户，连接的有效期不超过登录令牌
+			c.Set("user", &jwt.Token{Valid: true, Claims: &jwtCustomClaims{ticket.UserId, ticket.Name, ticket.Admin,
+				jwt.StandardClaims{ExpiresAt: ticket.SessionExpiresAt}}})
+			return next(c)
+		}
+	}
+}
+
+// signedOrJWT 带签名的文件地址由处理函数检查签名，否则按Authorization请求头验证令牌
+func (s *service) signedOrJWT(next echo.HandlerFunc) echo.HandlerFunc {
+	withJWT := middleware.JWTWithConfig(*s.jwtConfig)(next)
+	return func(c echo.Context) error {
+		if c.QueryParam("sig") != "" {
+			return next(c)
+		}
+		return withJWT(c)
+	}
+}
+
 func (s *service) renewval(c echo.Context) error {
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(*jwtCustomClaims)
@@ -376,6 +480,8 @@ func (s *service) registerAuthRoute() {
 	r := s.echo.Group("/auth")
 	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
 	r.GET("/renewval", s.renewval)
+	// ticket for EventSource and label links
+	r.POST("/ticket", s.issueTicket)
 	// user
 	r.POST("/user", s.addUser)
 	r.PUT("/user", s.updateUser)
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+func (s *service) addBorrowRequest(c echo.Context) error {
+	r := new(logic.BorrowRequest)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddBorrowRequest(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(r))
+}
+
+func (s *service) approveBorrowRequest(c echo.Context) error {
+	r := new(logic.BorrowApproval)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// approve
+	if err := s.lgc.ApproveBorrowRequest(currentUserID(c), r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) cancelBorrowRequest(c echo.Context) error {
+	id := uint(0)
+	// request id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// cancel
+	if err := s.lgc.CancelBorrowRequest(id, currentUserID(c)); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) listBorrowRequests(c echo.Context) error {
+	resId, _ := strconv.Atoi(c.QueryParam("resId"))
+	staffId, _ := strconv.Atoi(c.QueryParam("staffId"))
+	status, err := strconv.Atoi(c.QueryParam("status"))
+	if err != nil {
+		status = -1
+	}
+	param := &logic.BorrowQueryParam{
+		ResID:   uint(resId),
+		StaffID: uint(staffId),
+		Status:  int16(status),
+	}
+	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+	// query all
+	data, err := s.lgc.ListBorrowRequests(param, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) registerBorrowRoute() {
+	r := s.echo.Group("/res")
+	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	// borrow request
+	r.POST("/borrow_request", s.addBorrowRequest)
+	r.PUT("/borrow_request/approve", s.approveBorrowRequest)
+	r.DELETE("/borrow_request/:id", s.cancelBorrowRequest)
+	r.GET("/borrow_requests", s.listBorrowRequests)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+func (s *service) listStaffCerts(c echo.Context) error {
+	id := uint(0)
+	// staff id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	data, err := s.lgc.ListStaffCerts(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) addStaffCert(c echo.Context) error {
+	r := new(logic.StaffCert)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddStaffCert(r, currentUserID(c)); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) updateStaffCert(c echo.Context) error {
+	r := new(logic.StaffCert)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateStaffCert(r, currentUserID(c)); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) deleteStaffCert(c echo.Context) error {
+	id := uint(0)
+	// cert id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteStaffCert(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) listCertAlerts(c echo.Context) error {
+	days, err := strconv.Atoi(c.QueryParam("days"))
+	if err != nil {
+		days = 30
+	}
+	data, err := s.lgc.ListCertAlerts(days)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) listCertRules(c echo.Context) error {
+	data, err := s.lgc.ListCertRules()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) addCertRule(c echo.Context) error {
+	r := new(logic.CertRule)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddCertRule(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) updateCertRule(c echo.Context) error {
+	r := new(logic.CertRule)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateCertRule(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) deleteCertRule(c echo.Context) error {
+	id := uint(0)
+	// rule id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteCertRule(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) registerCertRoute() {
+	r := s.echo.Group("/sys")
+	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	// staff cert
+	r.GET("/staff/:id/certs", s.listStaffCerts)
+	r.POST("/staff_cert", s.addStaffCert)
+	r.PUT("/staff_cert", s.updateStaffCert)
+	r.DELETE("/staff_cert/:id", s.deleteStaffCert)
+	r.GET("/cert_alerts", s.listCertAlerts)
+
+	g := s.echo.Group("/res")
+	g.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	// cert rule
+	g.GET("/cert_rules", s.listCertRules)
+	g.POST("/cert_rule", s.addCertRule)
+	g.PUT("/cert_rule", s.updateCertRule)
+	g.DELETE("/cert_rule/:id", s.deleteCertRule)
+}
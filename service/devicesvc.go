@@ -0,0 +1,270 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+const (
+	// maxCommandPollWait 长轮询最长等待秒数
+	maxCommandPollWait = 30
+	// commandPollInterval 长轮询期间查询其他副本创建的指令的间隔
+	commandPollInterval = 2 * time.Second
+)
+
+func (s *service) getCabinetConfig(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	data, err := s.lgc.GetCabinetConfig(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) sendCabinetCommand(c echo.Context) error {
+	r := new(logic.CabinetCommand)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &r.CabinetID).BindError(); err != nil {
+		return err
+	}
+	// send
+	if err := s.lgc.SendCabinetCommand(r, currentUserID(c)); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(r))
+}
+
+func (s *service) cabinetHeartbeat(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	r := new(logic.CabinetHeartbeat)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := s.lgc.HandleDeviceEvent(id, &logic.DeviceEvent{Type: logic.DeviceHeartbeat, CabinetHeartbeat: *r}); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) listCabinetStatusLogs(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+	data, err := s.lgc.ListCabinetStatusLogs(id, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) listCabinetCommands(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	status, err := strconv.Atoi(c.QueryParam("status"))
+	if err != nil {
+		status = -1
+	}
+	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+	data, err := s.lgc.ListCabinetCommands(id, status, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) queryCabinetCommand(c echo.Context) error {
+	id := uint(0)
+	// command id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	data, err := s.lgc.QueryCabinetCommand(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+// pollCabinetCommands 设备长轮询获取指令，没有指令时最多等待wait秒
+func (s *service) pollCabinetCommands(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	wait, _ := strconv.Atoi(c.QueryParam("wait"))
+	if wait <= 0 || wait > maxCommandPollWait {
+		wait = maxCommandPollWait
+	}
+	// 先订阅再查询，避免漏掉期间本副本创建的指令；其他副本创建的指令由定时查询获取
+	events, unsubscribe := s.lgc.Bus().Subscribe([]string{logic.TopicCabinetCommand})
+	defer unsubscribe()
+	deadline := time.NewTimer(time.Duration(wait) * time.Second)
+	defer deadline.Stop()
+	ticker := time.NewTicker(commandPollInterval)
+	defer ticker.Stop()
+	for {
+		cmds, err := s.lgc.DeliverCabinetCommands(id)
+		if err != nil {
+			return err
+		}
+		if len(cmds) > 0 {
+			return c.JSON(http.StatusOK, common.NewHttpMsgData(cmds))
+		}
+	idle:
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-deadline.C:
+				return c.JSON(http.StatusOK, common.NewHttpMsgData([]logic.CabinetCommand{}))
+			case <-ticker.C:
+				break idle
+			case event := <-events:
+				if cmd, ok := event.Data.(*logic.CabinetCommand); ok && cmd.CabinetID == id {
+					break idle
+				}
+			}
+		}
+	}
+}
+
+// deviceEvent 未接入MQTT的智能柜通过接口上报事件
+func (s *service) deviceEvent(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	r := new(logic.DeviceEvent)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if err := s.lgc.HandleDeviceEvent(id, r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) syncDeviceEvents(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	r := new(logic.DeviceSyncBatch)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	data, err := s.lgc.SyncDeviceEvents(id, r.Events)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) getDeviceSyncReport(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// 默认查询被拒绝的事件
+	status, err := strconv.Atoi(c.QueryParam("status"))
+	if err != nil {
+		status = int(logic.DeviceEventRejected)
+	}
+	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+	data, err := s.lgc.GetDeviceSyncReport(id, status, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+// issueCabinetToken 签发设备令牌，配置到智能柜后调用设备接口
+func (s *service) issueCabinetToken(c echo.Context) error {
+	id := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	token, err := s.lgc.IssueCabinetToken(id, currentUserID(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(echo.Map{"token": token}))
+}
+
+// deviceAuth 验证Authorization请求头中的设备令牌，令牌须为路径中的智能柜签发
+func (s *service) deviceAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := uint(0)
+		// cabinet id
+		if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+			return err
+		}
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth {
+			return echo.ErrUnauthorized
+		}
+		ok, err := s.lgc.CheckCabinetToken(id, token)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return echo.ErrUnauthorized
+		}
+		return next(c)
+	}
+}
+
+func (s *service) registerDeviceRoute() {
+	r := s.echo.Group("/device")
+	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	r.GET("/cabinet/:id/config", s.getCabinetConfig)
+	r.POST("/cabinet/:id/command", s.sendCabinetCommand)
+	r.GET("/cabinet/:id/commands", s.listCabinetCommands)
+	r.GET("/command/:id", s.queryCabinetCommand)
+	r.GET("/cabinet/:id/sync_report", s.getDeviceSyncReport)
+	r.GET("/cabinet/:id/status_logs", s.listCabinetStatusLogs)
+	r.POST("/cabinet/:id/token", s.issueCabinetToken)
+
+	// 设备调用的接口使用设备令牌，不接受用户令牌
+	d := s.echo.Group("/device/cabinet/:id")
+	// 设备获取指令，指令通过command_ack事件返回结果
+	d.GET("/commands/poll", s.pollCabinetCommands, s.deviceAuth)
+	d.POST("/event", s.deviceEvent, s.deviceAuth)
+	// 离线期间的事件补传，按序号顺序处理。借出检查不通过时按离线放行记录，只接受设备令牌
+	d.POST("/sync", s.syncDeviceEvents, s.deviceAuth)
+	// 未接入MQTT的智能柜通过接口上报心跳
+	d.POST("/heartbeat", s.cabinetHeartbeat, s.deviceAuth)
+}
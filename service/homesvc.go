@@ -7,6 +7,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"zone.com/common"
+	"zone.com/logic"
 )
 
 func (s *service) statAllRes(c echo.Context) error {
@@ -45,6 +46,34 @@ func (s *service) statSlingByStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
 }
 
+func (s *service) statUsageTrend(c echo.Context) error {
+	param := &logic.UsageTrendParam{
+		StartTime: c.QueryParam("startTime"),
+		EndTime:   c.QueryParam("endTime"),
+		Unit:      c.QueryParam("unit"),
+		GroupBy:   c.QueryParam("groupBy"),
+	}
+	data, err := s.lgc.StatUsageTrend(param)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) statCabinetOccupancy(c echo.Context) error {
+	cabinetId, _ := strconv.Atoi(c.QueryParam("cabinetId"))
+	param := &logic.OccupancyParam{
+		CabinetID: uint(cabinetId),
+		StartTime: c.QueryParam("startTime"),
+		EndTime:   c.QueryParam("endTime"),
+	}
+	data, err := s.lgc.StatCabinetOccupancy(param)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
 func (s *service) registerStatRoute() {
 	r := s.echo.Group("/home")
 	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
@@ -52,4 +81,6 @@ func (s *service) registerStatRoute() {
 	r.GET("/stat_sling_by_ton", s.statSlingByTon)
 	r.GET("/sling_used_top", s.getSlingUsedTop)
 	r.GET("/stat_sling_by_status", s.statSlingByStatus)
+	r.GET("/stat_usage_trend", s.statUsageTrend)
+	r.GET("/stat_cabinet_occupancy", s.statCabinetOccupancy)
 }
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+// writeLabel 生成标签后输出，生成失败时返回错误信息
+func writeLabel(c echo.Context, filename string, contentType string, write func(buf *bytes.Buffer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", filename))
+	return c.Blob(http.StatusOK, contentType, buf.Bytes())
+}
+
+func (s *service) slingLabel(c echo.Context) error {
+	id := uint(0)
+	// sling id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	return writeLabel(c, fmt.Sprintf("sling_%d.png", id), "image/png", func(buf *bytes.Buffer) error {
+		return s.lgc.SlingLabelPNG(buf, id)
+	})
+}
+
+func (s *service) slingLabels(c echo.Context) error {
+	// ids为空时按吊索具列表的查询条件生成
+	var ids []uint
+	for _, v := range strings.Split(c.QueryParam("ids"), ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	}
+	param := slingQueryParam(c)
+	return writeLabel(c, "sling_labels.pdf", logic.ReportContentTypes[logic.ReportPDF], func(buf *bytes.Buffer) error {
+		return s.lgc.SlingLabelsPDF(buf, ids, param)
+	})
+}
+
+func (s *service) gridLabel(c echo.Context) error {
+	var cabinetID, gridNo uint
+	// cabinet id and grid no
+	if err := echo.PathParamsBinder(c).Uint("cabinetId", &cabinetID).Uint("gridNo", &gridNo).BindError(); err != nil {
+		return err
+	}
+	return writeLabel(c, fmt.Sprintf("grid_%d_%d.png", cabinetID, gridNo), "image/png", func(buf *bytes.Buffer) error {
+		return s.lgc.GridLabelPNG(buf, cabinetID, gridNo)
+	})
+}
+
+func (s *service) gridLabels(c echo.Context) error {
+	cabinetID := uint(0)
+	// cabinet id
+	if err := echo.PathParamsBinder(c).Uint("cabinetId", &cabinetID).BindError(); err != nil {
+		return err
+	}
+	return writeLabel(c, fmt.Sprintf("grid_labels_%d.pdf", cabinetID), logic.ReportContentTypes[logic.ReportPDF], func(buf *bytes.Buffer) error {
+		return s.lgc.GridLabelsPDF(buf, cabinetID)
+	})
+}
+
+func (s *service) scanLabel(c echo.Context) error {
+	data := make(map[string]interface{})
+	if err := c.Bind(&data); err != nil {
+		return err
+	}
+	payload, ok := data["payload"].(string)
+	if !ok {
+		return common.ErrBadQueryParams
+	}
+	result, err := s.lgc.ScanLabel(payload)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(result))
+}
+
+func (s *service) registerLabelRoute() {
+	r := s.echo.Group("/label")
+	r.Use(s.ticketOrJWT(ticketLabel))
+	r.GET("/sling/:id", s.slingLabel)
+	r.GET("/slings", s.slingLabels)
+	r.GET("/grid/:cabinetId/:gridNo", s.gridLabel)
+	r.GET("/grids/:cabinetId", s.gridLabels)
+	// 扫码，RFID标签丢失时借还可传qrCode代替resId
+	r.POST("/scan", s.scanLabel)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
+	"zone.com/logic"
+)
+
+const (
+	// liveStatsInterval 实时统计推送间隔
+	liveStatsInterval = 30 * time.Second
+	// liveHeartbeat 连接保活间隔
+	liveHeartbeat = 25 * time.Second
+)
+
+// liveStream Server-Sent Events推送，topics为逗号分隔的订阅主题，为空时订阅全部。
+// 连接在令牌到期时关闭，客户端需续期后重连
+func (s *service) liveStream(c echo.Context) error {
+	var topics []string
+	for _, v := range strings.Split(c.QueryParam("topics"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			topics = append(topics, v)
+		}
+	}
+	events, cancel := s.lgc.Bus().Subscribe(topics)
+	defer cancel()
+
+	expire := time.NewTimer(time.Hour)
+	defer expire.Stop()
+	if token, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := token.Claims.(*jwtCustomClaims); ok {
+			expire.Reset(time.Until(time.Unix(claims.ExpiresAt, 0)))
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	// 连接后先推送一次统计数据
+	if stats, err := s.lgc.LiveStats(); err == nil && (len(topics) == 0 || contains(topics, logic.TopicStats)) {
+		if err := writeLiveEvent(res, logic.BusEvent{Topic: logic.TopicStats, Data: stats}); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-expire.C:
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := writeLiveEvent(res, event); err != nil {
+				return nil
+			}
+		}
+		res.Flush()
+	}
+}
+
+// writeLiveEvent 写入一条SSE消息
+func writeLiveEvent(res *echo.Response, event logic.BusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Topic, data)
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runLive 定时推送统计数据、逾期提醒和智能柜状态变化，每个副本都需运行以推送给各自连接的客户端
+func (s *service) runLive(ctx context.Context) {
+	statsTicker := time.NewTicker(liveStatsInterval)
+	defer statsTicker.Stop()
+	overdueTicker := time.NewTicker(time.Minute)
+	defer overdueTicker.Stop()
+	// 各自记录上次成功的时间，一个失败不影响另一个
+	overdueSince, statusSince := time.Now(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-statsTicker.C:
+			if !s.lgc.Bus().HasSubscribers(logic.TopicStats) {
+				continue
+			}
+			stats, err := s.lgc.LiveStats()
+			if err != nil {
+				s.echo.Logger.Error(err)
+				continue
+			}
+			s.lgc.Bus().Publish(logic.TopicStats, stats)
+		case now := <-overdueTicker.C:
+			if err := s.lgc.PublishOverdueLoans(overdueSince, now); err != nil {
+				s.echo.Logger.Error(err)
+			} else {
+				overdueSince = now
+			}
+			if err := s.lgc.PublishCabinetStatus(statusSince, now); err != nil {
+				s.echo.Logger.Error(err)
+			} else {
+				statusSince = now
+			}
+		}
+	}
+}
+
+func (s *service) registerLiveRoute() {
+	r := s.echo.Group("/live")
+	r.Use(s.ticketOrJWT(ticketLive))
+	r.GET("/stream", s.liveStream)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"zone.com/logic"
+)
+
+// metricsRefresh 业务指标缓存时间，多个Prometheus同时采集时只查询一次数据库
+const metricsRefresh = 15 * time.Second
+
+var (
+	// loginFailures 登录失败次数
+	loginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zone",
+		Name:      "login_failures_total",
+		Help:      "Number of failed logins by reason.",
+	}, []string{"reason"})
+
+	slingsByUseStatusDesc = prometheus.NewDesc("zone_slings_by_use_status",
+		"Number of slings by use status.", []string{"status"}, nil)
+	slingsByInspectStatusDesc = prometheus.NewDesc("zone_slings_by_inspect_status",
+		"Number of slings by inspection status.", []string{"status"}, nil)
+	loansOutDesc = prometheus.NewDesc("zone_loans_out",
+		"Number of slings currently lent out.", nil, nil)
+	loansOverdueDesc = prometheus.NewDesc("zone_loans_overdue",
+		"Number of loans past their planned return time.", nil, nil)
+	freeGridsDesc = prometheus.NewDesc("zone_cabinet_free_grids",
+		"Number of empty and usable grids per cabinet.", []string{"cabinet_id", "cabinet"}, nil)
+	gridsDesc = prometheus.NewDesc("zone_cabinet_grids",
+		"Number of grids per cabinet.", []string{"cabinet_id", "cabinet"}, nil)
+)
+
+// metricsCollector 采集时从数据库统计业务指标
+type metricsCollector struct {
+	lgc       *logic.Logics
+	mu        sync.Mutex
+	metrics   *logic.BusinessMetrics
+	refreshed time.Time
+}
+
+func (m *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- slingsByUseStatusDesc
+	ch <- slingsByInspectStatusDesc
+	ch <- loansOutDesc
+	ch <- loansOverdueDesc
+	ch <- freeGridsDesc
+	ch <- gridsDesc
+}
+
+func (m *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	if m.metrics == nil || time.Since(m.refreshed) > metricsRefresh {
+		if metrics, err := m.lgc.StatBusinessMetrics(); err == nil {
+			m.metrics, m.refreshed = metrics, time.Now()
+		}
+	}
+	metrics := m.metrics
+	m.mu.Unlock()
+	if metrics == nil {
+		return
+	}
+
+	for status, count := range metrics.SlingsByUseStatus {
+		ch <- prometheus.MustNewConstMetric(slingsByUseStatusDesc, prometheus.GaugeValue, float64(count), fmt.Sprint(status))
+	}
+	for status, count := range metrics.SlingsByInspectStatus {
+		ch <- prometheus.MustNewConstMetric(slingsByInspectStatusDesc, prometheus.GaugeValue, float64(count), fmt.Sprint(status))
+	}
+	ch <- prometheus.MustNewConstMetric(loansOutDesc, prometheus.GaugeValue, float64(metrics.LoansOut))
+	ch <- prometheus.MustNewConstMetric(loansOverdueDesc, prometheus.GaugeValue, float64(metrics.LoansOverdue))
+	for i := range metrics.Cabinets {
+		c := &metrics.Cabinets[i]
+		id := fmt.Sprint(c.CabinetID)
+		ch <- prometheus.MustNewConstMetric(freeGridsDesc, prometheus.GaugeValue, float64(c.FreeGrids()), id, c.CabinetName)
+		ch <- prometheus.MustNewConstMetric(gridsDesc, prometheus.GaugeValue, float64(c.GridCount), id, c.CabinetName)
+	}
+}
+
+// registerMetrics 在echo-contrib prometheus使用的默认注册表中注册业务指标
+func (s *service) registerMetrics() {
+	prometheus.MustRegister(loginFailures, logic.TakeReturnOps, &metricsCollector{lgc: s.lgc})
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/labstack/echo/v4"
+	"zone.com/common"
+	"zone.com/logic"
+	"zone.com/util"
+)
+
+// 智能柜MQTT主题，id为智能柜ID：
+//
+//	<prefix>/cabinet/<id>/event   设备上报事件，QoS 1
+//	<prefix>/cabinet/<id>/reply   事件处理结果，QoS 1
+//	<prefix>/cabinet/<id>/command 下发指令，QoS 1，设备执行后通过command_ack事件返回结果
+//	<prefix>/cabinet/<id>/config  智能柜配置，QoS 1，保留消息，设备连接后即可读取
+const (
+	mqttQoS     = 1
+	mqttTimeout = 10 * time.Second
+)
+
+// mqttReply 事件处理结果
+type mqttReply struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// deviceLogic 智能柜MQTT连接使用的业务方法
+type deviceLogic interface {
+	HandleDeviceEvent(cabinetID uint, e *logic.DeviceEvent) error
+	GetCabinetConfig(cabinetID uint) (*logic.CabinetConfig, error)
+	ListCabinetIDs() ([]uint, error)
+	PendingCabinetCommands(cabinetID uint) ([]logic.CabinetCommand, error)
+	MarkCabinetCommandDelivered(id uint) error
+}
+
+// mqttDevice 智能柜MQTT连接
+type mqttDevice struct {
+	lgc    deviceLogic
+	logger echo.Logger
+	client mqtt.Client
+	prefix string
+	filter string // 事件订阅，多副本使用共享订阅，每条事件只由一个副本处理
+}
+
+func mqttTopic(prefix string, cabinetID uint, kind string) string {
+	return fmt.Sprintf("%s/cabinet/%d/%s", prefix, cabinetID, kind)
+}
+
+// runMQTT 连接MQTT服务器，接收智能柜事件并转发指令和配置，每个副本都需运行
+func (s *service) runMQTT(ctx context.Context) {
+	if util.MQTTBroker == "" {
+		return
+	}
+	clientID := util.MQTTClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "zone-" + hostname
+	}
+	prefix := util.MQTTTopicPrefix
+	d := &mqttDevice{
+		lgc:    s.lgc,
+		logger: s.echo.Logger,
+		prefix: prefix,
+		filter: fmt.Sprintf("$share/%s/%s/cabinet/+/event", prefix, prefix),
+	}
+	d.client = mqtt.NewClient(d.options(util.MQTTBroker, clientID).
+		SetUsername(util.MQTTUser).
+		SetPassword(util.MQTTPassword))
+	d.client.Connect()
+	defer d.client.Disconnect(250)
+
+	events, unsubscribe := s.lgc.Bus().Subscribe([]string{logic.TopicCabinetCommand, logic.TopicGridChanged,
+		logic.TopicSlingTake, logic.TopicSlingReturn})
+	defer unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			d.forward(event)
+		}
+	}
+}
+
+// options 连接参数。保留会话，断线期间的QoS 1消息在重连后继续投递
+func (d *mqttDevice) options(broker string, clientID string) *mqtt.ClientOptions {
+	return mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOrderMatters(true).
+		SetOnConnectHandler(d.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			d.logger.Warnf("mqtt connection lost: %v", err)
+		})
+}
+
+// onConnect 订阅事件，发布所有智能柜的配置并推送排队中的指令
+func (d *mqttDevice) onConnect(client mqtt.Client) {
+	if token := client.Subscribe(d.filter, mqttQoS, d.onEvent); token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+		d.logger.Error(token.Error())
+	}
+	ids, err := d.lgc.ListCabinetIDs()
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	for _, id := range ids {
+		d.publishConfig(id)
+	}
+	// 多个副本重连时可能重复推送，设备按指令ID去重
+	cmds, err := d.lgc.PendingCabinetCommands(0)
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	for i := range cmds {
+		d.publishCommand(&cmds[i])
+	}
+}
+
+// onEvent 处理设备事件并回复结果。QoS 1重复投递的消息按消息ID返回首次处理的结果
+func (d *mqttDevice) onEvent(client mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(msg.Topic(), "/")
+	if len(parts) < 3 {
+		return
+	}
+	id, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return
+	}
+	cabinetID := uint(id)
+	var event logic.DeviceEvent
+	if err := json.Unmarshal(msg.Payload(), &event); err != nil {
+		d.reply(cabinetID, &mqttReply{Code: common.ERR_BAD_REQUEST, Message: err.Error()})
+		return
+	}
+	reply := &mqttReply{ID: event.ID, Success: true, Code: common.SUCCESS}
+	if err := d.lgc.HandleDeviceEvent(cabinetID, &event); err != nil {
+		reply.Success, reply.Code, reply.Message = false, common.ERR_BAD_REQUEST, err.Error()
+		if he, ok := err.(*common.HttpError); ok {
+			reply.Code, reply.Message = he.Code, he.Message
+		}
+	}
+	// 心跳不回复
+	if event.Type != logic.DeviceHeartbeat {
+		d.reply(cabinetID, reply)
+	}
+}
+
+// forward 转发本副本产生的指令，箱格变化时更新配置
+func (d *mqttDevice) forward(event logic.BusEvent) {
+	switch data := event.Data.(type) {
+	case *logic.CabinetCommand:
+		if data.Command == logic.CommandSync {
+			d.publishConfig(data.CabinetID)
+		}
+		d.publishCommand(data)
+	case *logic.CabinetGrid:
+		d.publishConfig(data.CabinetID)
+	case *logic.ResEvent:
+		if data.CabinetID > 0 {
+			d.publishConfig(data.CabinetID)
+		}
+		if data.FromCabinetID > 0 && data.FromCabinetID != data.CabinetID {
+			d.publishConfig(data.FromCabinetID)
+		}
+	}
+}
+
+// publishConfig 发布智能柜配置为保留消息
+func (d *mqttDevice) publishConfig(cabinetID uint) {
+	config, err := d.lgc.GetCabinetConfig(cabinetID)
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	d.publish(mqttTopic(d.prefix, cabinetID, "config"), true, config)
+}
+
+// publishCommand 推送指令，服务器确认收到后标记为已下发
+func (d *mqttDevice) publishCommand(cmd *logic.CabinetCommand) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	token := d.client.Publish(mqttTopic(d.prefix, cmd.CabinetID, "command"), mqttQoS, false, payload)
+	go func() {
+		if !token.WaitTimeout(mqttTimeout) {
+			return
+		}
+		if err := token.Error(); err != nil {
+			d.logger.Error(err)
+			return
+		}
+		if err := d.lgc.MarkCabinetCommandDelivered(cmd.ID); err != nil {
+			d.logger.Error(err)
+		}
+	}()
+}
+
+func (d *mqttDevice) reply(cabinetID uint, reply *mqttReply) {
+	d.publish(mqttTopic(d.prefix, cabinetID, "reply"), false, reply)
+}
+
+// publish 不等待发送结果，消息处理函数中等待会阻塞后续消息；未连接时QoS 1消息由客户端在重连后发送
+func (d *mqttDevice) publish(topic string, retained bool, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		d.logger.Error(err)
+		return
+	}
+	token := d.client.Publish(topic, mqttQoS, retained, payload)
+	go func() {
+		if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+			d.logger.Error(token.Error())
+		}
+	}()
+}
@@ -0,0 +1,358 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/labstack/echo/v4"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+const testPrefix = "zone"
+
+// fakeDeviceLogic 按消息ID记录首次处理的结果，重复的消息返回该结果
+type fakeDeviceLogic struct {
+	mu        sync.Mutex
+	configs   map[uint]*logic.CabinetConfig
+	commands  []logic.CabinetCommand
+	applied   []appliedEvent
+	results   map[string]error
+	delivered chan uint
+}
+
+type appliedEvent struct {
+	cabinetID uint
+	event     logic.DeviceEvent
+}
+
+func newFakeDeviceLogic() *fakeDeviceLogic {
+	return &fakeDeviceLogic{
+		configs: map[uint]*logic.CabinetConfig{
+			1: {CabinetID: 1, Name: "A", GridCount: 2, Grids: []logic.DeviceGrid{{GridNo: 1, InResID: 5, InRfID: "rf5"}, {GridNo: 2}}},
+			2: {CabinetID: 2, Name: "B", GridCount: 1, Grids: []logic.DeviceGrid{{GridNo: 1}}},
+		},
+		results:   map[string]error{},
+		delivered: make(chan uint, 16),
+	}
+}
+
+func (f *fakeDeviceLogic) HandleDeviceEvent(cabinetID uint, e *logic.DeviceEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := fmt.Sprintf("%d/%s", cabinetID, e.ID)
+	if err, ok := f.results[key]; ok {
+		return err
+	}
+	var err error
+	switch {
+	case e.Type != logic.DeviceTake && e.Type != logic.DeviceReturn:
+	case e.ResID == 0 && e.RfID == "" && e.GridNo == 0:
+		err = common.ErrBadQueryParams
+	case e.Type == logic.DeviceTake && e.StaffID == 9:
+		err = common.ErrStaffNotQualified
+	default:
+		f.applied = append(f.applied, appliedEvent{cabinetID: cabinetID, event: *e})
+	}
+	f.results[key] = err
+	return err
+}
+
+func (f *fakeDeviceLogic) GetCabinetConfig(cabinetID uint) (*logic.CabinetConfig, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	config, ok := f.configs[cabinetID]
+	if !ok {
+		return nil, common.ErrNotFound
+	}
+	return config, nil
+}
+
+func (f *fakeDeviceLogic) ListCabinetIDs() ([]uint, error) {
+	return []uint{1, 2}, nil
+}
+
+func (f *fakeDeviceLogic) PendingCabinetCommands(cabinetID uint) ([]logic.CabinetCommand, error) {
+	return f.commands, nil
+}
+
+func (f *fakeDeviceLogic) MarkCabinetCommandDelivered(id uint) error {
+	f.delivered <- id
+	return nil
+}
+
+func (f *fakeDeviceLogic) appliedEvents() []appliedEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]appliedEvent(nil), f.applied...)
+}
+
+// startBroker 启动内嵌的MQTT服务器，返回地址
+func startBroker(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := broker.New()
+	if err := server.AddListener(listeners.NewTCP("t1", addr), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return "tcp://" + addr
+}
+
+// startDevice 连接服务端，内嵌服务器不支持共享订阅，使用普通订阅
+func startDevice(t *testing.T, addr string, lgc deviceLogic) *mqttDevice {
+	t.Helper()
+	d := &mqttDevice{
+		lgc:    lgc,
+		logger: echo.New().Logger,
+		prefix: testPrefix,
+		filter: testPrefix + "/cabinet/+/event",
+	}
+	d.client = mqtt.NewClient(d.options(addr, "zone-test"))
+	if token := d.client.Connect(); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("connect: %v", token.Error())
+	}
+	t.Cleanup(func() { d.client.Disconnect(250) })
+	return d
+}
+
+// cabinetClient 模拟智能柜，订阅topic并收集消息
+type cabinetClient struct {
+	t        *testing.T
+	client   mqtt.Client
+	messages chan mqtt.Message
+}
+
+func newCabinetClient(t *testing.T, addr string, clientID string, topic string) *cabinetClient {
+	t.Helper()
+	c := &cabinetClient{t: t, messages: make(chan mqtt.Message, 16)}
+	c.client = mqtt.NewClient(mqtt.NewClientOptions().AddBroker(addr).SetClientID(clientID))
+	if token := c.client.Connect(); !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("connect: %v", token.Error())
+	}
+	t.Cleanup(func() { c.client.Disconnect(250) })
+	token := c.client.Subscribe(topic, mqttQoS, func(_ mqtt.Client, msg mqtt.Message) {
+		c.messages <- msg
+	})
+	if !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		t.Fatalf("subscribe: %v", token.Error())
+	}
+	return c
+}
+
+func (c *cabinetClient) publish(cabinetID uint, event *logic.DeviceEvent) {
+	c.t.Helper()
+	payload, _ := json.Marshal(event)
+	token := c.client.Publish(mqttTopic(testPrefix, cabinetID, "event"), mqttQoS, false, payload)
+	if !token.WaitTimeout(mqttTimeout) || token.Error() != nil {
+		c.t.Fatalf("publish: %v", token.Error())
+	}
+}
+
+func (c *cabinetClient) next() mqtt.Message {
+	c.t.Helper()
+	select {
+	case msg := <-c.messages:
+		return msg
+	case <-time.After(mqttTimeout):
+		c.t.Fatal("timeout waiting for message")
+		return nil
+	}
+}
+
+func (c *cabinetClient) nextReply() *mqttReply {
+	c.t.Helper()
+	var reply mqttReply
+	if err := json.Unmarshal(c.next().Payload(), &reply); err != nil {
+		c.t.Fatal(err)
+	}
+	return &reply
+}
+
+func (c *cabinetClient) expectNone() {
+	c.t.Helper()
+	select {
+	case msg := <-c.messages:
+		c.t.Fatalf("unexpected message on %s: %s", msg.Topic(), msg.Payload())
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func waitDelivered(t *testing.T, lgc *fakeDeviceLogic, id uint) {
+	t.Helper()
+	select {
+	case got := <-lgc.delivered:
+		if got != id {
+			t.Fatalf("delivered command %d, want %d", got, id)
+		}
+	case <-time.After(mqttTimeout):
+		t.Fatalf("command %d not marked delivered", id)
+	}
+}
+
+func TestMQTTTakeReturnEvents(t *testing.T) {
+	addr := startBroker(t)
+	lgc := newFakeDeviceLogic()
+	startDevice(t, addr, lgc)
+	cabinet := newCabinetClient(t, addr, "cabinet-1", mqttTopic(testPrefix, 1, "reply"))
+
+	cabinet.publish(1, &logic.DeviceEvent{ID: "m1", Type: logic.DeviceTake, GridNo: 1, ResID: 5, StaffID: 3, Seq: 1})
+	if reply := cabinet.nextReply(); reply.ID != "m1" || !reply.Success || reply.Code != common.SUCCESS {
+		t.Fatalf("take reply = %+v", reply)
+	}
+	cabinet.publish(1, &logic.DeviceEvent{ID: "m2", Type: logic.DeviceReturn, GridNo: 2, RfID: "rf5", StaffID: 4, Seq: 2})
+	if reply := cabinet.nextReply(); reply.ID != "m2" || !reply.Success {
+		t.Fatalf("return reply = %+v", reply)
+	}
+
+	applied := lgc.appliedEvents()
+	if len(applied) != 2 {
+		t.Fatalf("applied %d events, want 2", len(applied))
+	}
+	take, ret := applied[0], applied[1]
+	if take.cabinetID != 1 || take.event.Type != logic.DeviceTake || take.event.ResID != 5 ||
+		take.event.StaffID != 3 || take.event.GridNo != 1 || take.event.Seq != 1 {
+		t.Errorf("take event = %+v", take)
+	}
+	if ret.cabinetID != 1 || ret.event.Type != logic.DeviceReturn || ret.event.RfID != "rf5" ||
+		ret.event.StaffID != 4 || ret.event.GridNo != 2 || ret.event.Seq != 2 {
+		t.Errorf("return event = %+v", ret)
+	}
+
+	// 失败时回复错误码
+	cabinet.publish(1, &logic.DeviceEvent{ID: "m3", Type: logic.DeviceTake, GridNo: 1, ResID: 5, StaffID: 9})
+	if reply := cabinet.nextReply(); reply.Success || reply.Code != common.ERR_STAFF_NOT_QUALIFIED {
+		t.Fatalf("rejected take reply = %+v", reply)
+	}
+	cabinet.publish(1, &logic.DeviceEvent{ID: "m4", Type: logic.DeviceTake})
+	if reply := cabinet.nextReply(); reply.Success || reply.Code != common.ERR_BAD_REQUEST {
+		t.Fatalf("bad take reply = %+v", reply)
+	}
+	// 心跳不回复
+	cabinet.publish(1, &logic.DeviceEvent{ID: "m5", Type: logic.DeviceHeartbeat})
+	cabinet.expectNone()
+}
+
+func TestMQTTRedelivery(t *testing.T) {
+	addr := startBroker(t)
+	lgc := newFakeDeviceLogic()
+	startDevice(t, addr, lgc)
+	cabinet := newCabinetClient(t, addr, "cabinet-1", mqttTopic(testPrefix, 1, "reply"))
+
+	// 设备未收到回复时按相同的消息ID重发
+	take := &logic.DeviceEvent{ID: "m1", Type: logic.DeviceTake, GridNo: 1, ResID: 5, StaffID: 3, Seq: 1}
+	cabinet.publish(1, take)
+	first := cabinet.nextReply()
+	cabinet.publish(1, take)
+	second := cabinet.nextReply()
+	if *first != *second || !first.Success {
+		t.Fatalf("replies differ: %+v, %+v", first, second)
+	}
+	rejected := &logic.DeviceEvent{ID: "m2", Type: logic.DeviceTake, GridNo: 1, ResID: 5, StaffID: 9, Seq: 2}
+	cabinet.publish(1, rejected)
+	first = cabinet.nextReply()
+	cabinet.publish(1, rejected)
+	second = cabinet.nextReply()
+	if *first != *second || first.Success {
+		t.Fatalf("replies differ: %+v, %+v", first, second)
+	}
+	if applied := lgc.appliedEvents(); len(applied) != 1 {
+		t.Fatalf("applied %d events, want 1", len(applied))
+	}
+}
+
+func TestMQTTConfigOnConnect(t *testing.T) {
+	addr := startBroker(t)
+	lgc := newFakeDeviceLogic()
+	lgc.commands = []logic.CabinetCommand{{BaseModel: logic.BaseModel{ID: 11}, CabinetID: 1, Command: logic.CommandOpenDoor, GridNo: 2}}
+	startDevice(t, addr, lgc)
+	// 指令在配置之后发布，指令确认后配置已保存为保留消息
+	waitDelivered(t, lgc, 11)
+
+	for _, id := range []uint{1, 2} {
+		cabinet := newCabinetClient(t, addr, fmt.Sprintf("cabinet-%d", id), mqttTopic(testPrefix, id, "config"))
+		msg := cabinet.next()
+		if !msg.Retained() {
+			t.Errorf("config of cabinet %d is not retained", id)
+		}
+		var config logic.CabinetConfig
+		if err := json.Unmarshal(msg.Payload(), &config); err != nil {
+			t.Fatal(err)
+		}
+		want := lgc.configs[id]
+		if config.CabinetID != id || config.Name != want.Name || len(config.Grids) != len(want.Grids) {
+			t.Errorf("config of cabinet %d = %+v", id, config)
+		}
+	}
+}
+
+func TestMQTTForward(t *testing.T) {
+	addr := startBroker(t)
+	lgc := newFakeDeviceLogic()
+	d := startDevice(t, addr, lgc)
+	commands := newCabinetClient(t, addr, "cabinet-1", mqttTopic(testPrefix, 1, "command"))
+
+	d.forward(logic.BusEvent{Topic: logic.TopicCabinetCommand,
+		Data: &logic.CabinetCommand{BaseModel: logic.BaseModel{ID: 12}, CabinetID: 1, Command: logic.CommandUnlockGrid, GridNo: 2}})
+	var cmd logic.CabinetCommand
+	if err := json.Unmarshal(commands.next().Payload(), &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.ID != 12 || cmd.CabinetID != 1 || cmd.Command != logic.CommandUnlockGrid || cmd.GridNo != 2 {
+		t.Fatalf("command = %+v", cmd)
+	}
+	waitDelivered(t, lgc, 12)
+
+	// 借还后更新取出和放回的智能柜的配置
+	configs := newCabinetClient(t, addr, "cabinet-all", testPrefix+"/cabinet/+/config")
+	for i := 0; i < 2; i++ {
+		configs.next() // 连接时发布的保留消息
+	}
+	lgc.mu.Lock()
+	lgc.configs[1] = &logic.CabinetConfig{CabinetID: 1, Name: "A", GridCount: 2, Grids: []logic.DeviceGrid{{GridNo: 1}, {GridNo: 2}}}
+	lgc.configs[2] = &logic.CabinetConfig{CabinetID: 2, Name: "B", GridCount: 1, Grids: []logic.DeviceGrid{{GridNo: 1, InResID: 5, InRfID: "rf5"}}}
+	lgc.mu.Unlock()
+	d.forward(logic.BusEvent{Topic: logic.TopicSlingReturn,
+		Data: &logic.ResEvent{EventType: logic.EventReturn, ResID: 5, CabinetID: 2, GridNo: 1, FromCabinetID: 1, FromGridNo: 1}})
+	updated := map[uint]logic.CabinetConfig{}
+	for i := 0; i < 2; i++ {
+		var config logic.CabinetConfig
+		if err := json.Unmarshal(configs.next().Payload(), &config); err != nil {
+			t.Fatal(err)
+		}
+		updated[config.CabinetID] = config
+	}
+	if c := updated[1]; len(c.Grids) != 2 || c.Grids[0].InResID != 0 {
+		t.Errorf("config of cabinet 1 = %+v", c)
+	}
+	if c := updated[2]; len(c.Grids) != 1 || c.Grids[0].InResID != 5 {
+		t.Errorf("config of cabinet 2 = %+v", c)
+	}
+
+	// 同步指令先更新配置
+	d.forward(logic.BusEvent{Topic: logic.TopicCabinetCommand,
+		Data: &logic.CabinetCommand{BaseModel: logic.BaseModel{ID: 13}, CabinetID: 1, Command: logic.CommandSync}})
+	if msg := configs.next(); msg.Topic() != mqttTopic(testPrefix, 1, "config") {
+		t.Errorf("sync published %s", msg.Topic())
+	}
+	if err := json.Unmarshal(commands.next().Payload(), &cmd); err != nil || cmd.ID != 13 {
+		t.Fatalf("sync command = %+v, %v", cmd, err)
+	}
+	waitDelivered(t, lgc, 13)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+// writeReport 检查格式后以附件形式输出报表，format默认为xlsx
+func writeReport(c echo.Context, name string, write func(w io.Writer, format string) error) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = logic.ReportXLSX
+	}
+	if err := logic.CheckReportFormat(format); err != nil {
+		return err
+	}
+	// 生成到临时文件后再输出，生成失败时返回错误信息，大报表不占用内存
+	file, err := os.CreateTemp("", "report-*."+format)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := write(file, format); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	filename := fmt.Sprintf("%s_%s.%s", name, time.Now().Format("20060102150405"), format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.Stream(http.StatusOK, logic.ReportContentTypes[format], file)
+}
+
+func (s *service) reportUseLogs(c echo.Context) error {
+	param := useLogQueryParam(c)
+	return writeReport(c, "use_logs", func(w io.Writer, format string) error {
+		return s.lgc.ReportUseLogs(w, format, param)
+	})
+}
+
+func (s *service) reportOverdue(c echo.Context) error {
+	param := useLogQueryParam(c)
+	return writeReport(c, "overdue", func(w io.Writer, format string) error {
+		return s.lgc.ReportOverdue(w, format, param)
+	})
+}
+
+func (s *service) reportSlings(c echo.Context) error {
+	param := slingQueryParam(c)
+	return writeReport(c, "slings", func(w io.Writer, format string) error {
+		return s.lgc.ReportSlings(w, format, param)
+	})
+}
+
+func (s *service) reportInspection(c echo.Context) error {
+	param := slingQueryParam(c)
+	return writeReport(c, "inspection", func(w io.Writer, format string) error {
+		return s.lgc.ReportInspection(w, format, param)
+	})
+}
+
+func (s *service) listReportSubscriptions(c echo.Context) error {
+	data, err := s.lgc.ListReportSubscriptions()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) addReportSubscription(c echo.Context) error {
+	r := new(logic.ReportSubscription)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddReportSubscription(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) updateReportSubscription(c echo.Context) error {
+	r := new(logic.ReportSubscription)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateReportSubscription(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) deleteReportSubscription(c echo.Context) error {
+	id := uint(0)
+	// subscription id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteReportSubscription(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) runReportSubscription(c echo.Context) error {
+	id := uint(0)
+	// subscription id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// send now
+	if err := s.lgc.RunReportSubscription(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) registerReportRoute() {
+	r := s.echo.Group("/report")
+	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	r.GET("/use_logs", s.reportUseLogs)
+	r.GET("/overdue", s.reportOverdue)
+	r.GET("/slings", s.reportSlings)
+	r.GET("/inspection", s.reportInspection)
+	// subscription
+	r.GET("/subscriptions", s.listReportSubscriptions)
+	r.POST("/subscription", s.addReportSubscription)
+	r.PUT("/subscription", s.updateReportSubscription)
+	r.DELETE("/subscription/:id", s.deleteReportSubscription)
+	r.POST("/subscription/:id/run", s.runReportSubscription)
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"zone.com/logic"
+)
+
+func reportTempFiles(t *testing.T) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "report-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestWriteReport(t *testing.T) {
+	before := len(reportTempFiles(t))
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/report?format=xlsx", nil), rec)
+	err := writeReport(c, "use_logs", func(w io.Writer, format string) error {
+		_, err := io.WriteString(w, strings.Repeat("x", 100000))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 100000 {
+		t.Errorf("code = %d, length = %d", rec.Code, rec.Body.Len())
+	}
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != logic.ReportContentTypes[logic.ReportXLSX] {
+		t.Errorf("content type = %q", ct)
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, "use_logs_") {
+		t.Errorf("content disposition = %q", cd)
+	}
+
+	// 生成失败时不输出内容，由错误处理返回错误信息
+	failed := errors.New("failed")
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/report", nil), rec)
+	err = writeReport(c, "use_logs", func(w io.Writer, format string) error {
+		io.WriteString(w, "partial")
+		return failed
+	})
+	if err != failed || c.Response().Committed {
+		t.Errorf("err = %v, committed = %v", err, c.Response().Committed)
+	}
+	if after := len(reportTempFiles(t)); after != before {
+		t.Errorf("temp files = %d, want %d", after, before)
+	}
+}
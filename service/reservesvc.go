@@ -0,0 +1,67 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+func (s *service) addReservation(c echo.Context) error {
+	r := new(logic.Reservation)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddReservation(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(r))
+}
+
+func (s *service) cancelReservation(c echo.Context) error {
+	id := uint(0)
+	// reservation id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// cancel
+	if err := s.lgc.CancelReservation(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) listReservations(c echo.Context) error {
+	resId, _ := strconv.Atoi(c.QueryParam("resId"))
+	staffId, _ := strconv.Atoi(c.QueryParam("staffId"))
+	status, err := strconv.Atoi(c.QueryParam("status"))
+	if err != nil {
+		status = -1
+	}
+	param := &logic.ReservationQueryParam{
+		ResID:   uint(resId),
+		StaffID: uint(staffId),
+		Status:  int16(status),
+	}
+	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
+	// query all
+	data, err := s.lgc.ListReservations(param, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) registerReserveRoute() {
+	r := s.echo.Group("/res")
+	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	// reservation
+	r.POST("/reservation", s.addReservation)
+	r.DELETE("/reservation/:id", s.cancelReservation)
+	r.GET("/reservations", s.listReservations)
+}
@@ -47,17 +47,42 @@ func (s *service) deleteSling(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
 }
 
-func (s *service) listSlings(c echo.Context) error {
-	name := c.QueryParam("name")
+// slingQueryParam 吊索具查询条件，列表和报表共用
+func slingQueryParam(c echo.Context) *logic.SlingQueryParam {
 	slingType, _ := strconv.Atoi(c.QueryParam("slingType"))
 	maxTonnage, _ := strconv.Atoi(c.QueryParam("maxTonnage"))
 	useStatus, _ := strconv.Atoi(c.QueryParam("useStatus"))
 	inspectStatus, _ := strconv.Atoi(c.QueryParam("inspectStatus"))
+	return &logic.SlingQueryParam{
+		Name:          c.QueryParam("name"),
+		SlingType:     uint(slingType),
+		MaxTonnage:    uint(maxTonnage),
+		UseStatus:     uint(useStatus),
+		InspectStatus: uint(inspectStatus),
+	}
+}
+
+func (s *service) listSlings(c echo.Context) error {
+	param := slingQueryParam(c)
 	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
 	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
 	// query all
-	data, err := s.lgc.ListSlings(name, uint(slingType), uint(maxTonnage),
-		uint(useStatus), uint(inspectStatus), pageIndex, pageSize)
+	data, err := s.lgc.ListSlings(param.Name, param.SlingType, param.MaxTonnage,
+		param.UseStatus, param.InspectStatus, pageIndex, pageSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) getSlingHistory(c echo.Context) error {
+	id := uint(0)
+	// Sling id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// query
+	data, err := s.lgc.GetSlingHistory(id)
 	if err != nil {
 		return err
 	}
@@ -127,6 +152,18 @@ func (s *service) listGrids(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
 }
 
+func (s *service) updateGrid(c echo.Context) error {
+	r := new(logic.CabinetGrid)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateCabinetGrid(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
 func (s *service) registerResRoute() {
 	r := s.echo.Group("/res")
 	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
@@ -135,10 +172,12 @@ func (s *service) registerResRoute() {
 	r.PUT("/sling", s.updateSling)
 	r.DELETE("/sling/:id", s.deleteSling)
 	r.GET("/slings", s.listSlings)
+	r.GET("/sling/:id/history", s.getSlingHistory)
 	// cabinet
 	r.POST("/cabinet", s.addCabinet)
 	r.PUT("/cabinet", s.updateCabinet)
 	r.DELETE("/cabinet/:id", s.deleteCabinet)
 	r.GET("/cabinets", s.listCabinets)
 	r.GET("/cabinet_grids/:id", s.listGrids)
+	r.PUT("/cabinet_grid", s.updateGrid)
 }
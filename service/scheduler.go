@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"zone.com/util"
+)
+
+// schedulerLockKey 定时任务主节点咨询锁
+const schedulerLockKey int64 = 0x5a4f4e45
+
+// RunScheduler 每分钟执行一次定时任务，多副本部署时只有取得咨询锁的主节点执行
+func (s *service) RunScheduler(ctx context.Context) {
+	// 实时推送和设备连接不需要主节点
+	go s.runLive(ctx)
+	go s.runMQTT(ctx)
+
+	lock := util.NewLeaderLock(s.db, schedulerLockKey)
+	defer lock.Release()
+	jobs := []func() error{
+		// 定时报表
+		s.lgc.RunDueReportSubscriptions,
+		// 智能柜离线检测
+		s.lgc.MarkOfflineCabinets,
+		// 智能柜指令超时
+		s.lgc.ExpireCabinetCommands,
+		// 过期的设备消息ID
+		s.lgc.CleanDeviceMessages,
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		leader, err := lock.TryLead(ctx)
+		if err != nil {
+			s.echo.Logger.Error(err)
+		}
+		if leader {
+			for _, job := range jobs {
+				if err := job(); err != nil {
+					s.echo.Logger.Error(err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/gorm"
@@ -11,7 +13,11 @@ import (
 // Service service methods
 type Service interface {
 	SetConfig(echo *echo.Echo, db *gorm.DB)
+	Migrate() error
+	InitStorage() error
+	MigrateStorage(from string) (int, int, error)
 	RegisterServices()
+	RunScheduler(ctx context.Context)
 }
 
 // NewService create a new service instance
@@ -38,6 +44,25 @@ func (s *service) SetConfig(echo *echo.Echo, db *gorm.DB) {
 	}
 }
 
+// Migrate
+func (s *service) Migrate() error {
+	return s.lgc.AutoMigrate()
+}
+
+// InitStorage
+func (s *service) InitStorage() error {
+	return s.lgc.InitStorage()
+}
+
+// MigrateStorage 返回复制和跳过的文件数
+func (s *service) MigrateStorage(from string) (int, int, error) {
+	store, err := logic.NewStorage(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.lgc.MigrateStorage(store)
+}
+
 // RegisterServices
 func (s *service) RegisterServices() {
 	// auth
@@ -50,10 +75,34 @@ func (s *service) RegisterServices() {
 	s.registerResRoute()
 	// usage
 	s.registerUsageRoute()
+	// reservation
+	s.registerReserveRoute()
+	// borrow request
+	s.registerBorrowRoute()
+	// certification
+	s.registerCertRoute()
+	// wear
+	s.registerWearRoute()
+	// report
+	s.registerReportRoute()
+	// live updates
+	s.registerLiveRoute()
+	// labels
+	s.registerLabelRoute()
+	// cabinet devices
+	s.registerDeviceRoute()
+	// business metrics
+	s.registerMetrics()
 
+	// file download, signed urls for img
+	s.echo.GET("/file/:id", s.downloadFile, s.signedOrJWT)
 	// file upload
 	r := s.echo.Group("/file")
 	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
 	r.POST("/upload", s.upload)
+	r.DELETE("/:id", s.deleteFile)
+	// attachments, removed by deleting the file
+	r.GET("/attachments", s.listAttachments)
+	r.POST("/attachment", s.addAttachment)
 
 }
@@ -39,7 +39,7 @@ func (s *service) addStaff(c echo.Context) error {
 		return err
 	}
 	// add
-	if err := s.lgc.AddStaff(r); err != nil {
+	if err := s.lgc.AddStaff(r, currentUserID(c)); err != nil {
 		return err
 	}
 	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
@@ -51,7 +51,7 @@ func (s *service) updateStaff(c echo.Context) error {
 		return err
 	}
 	// update
-	if err := s.lgc.UpdateStaff(r); err != nil {
+	if err := s.lgc.UpdateStaff(r, currentUserID(c)); err != nil {
 		return err
 	}
 	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
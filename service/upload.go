@@ -1,96 +1,158 @@
 package service
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"zone.com/common"
-	"zone.com/util"
+	"zone.com/logic"
 )
 
-func (s *service) upload(c echo.Context) error {
-	//-----------
-	// Read file
-	//-----------
+// multipartOverhead 请求体中表单字段和分隔符的余量
+const multipartOverhead = 64 << 10
 
-	// Source
-	clientfd, err := c.FormFile("file")
-	if err != nil {
-		return err
+// upload 逐段读取multipart请求体，文件内容直接写入存储，不整体缓存请求体。
+// 关联对象和文件名可放在查询参数或文件之前的表单字段中
+func (s *service) upload(c echo.Context) error {
+	req := c.Request()
+	fields := map[string]string{
+		"entityType": c.QueryParam("entityType"),
+		"entityId":   c.QueryParam("entityId"),
+		"name":       c.QueryParam("name"),
+		"category":   c.QueryParam("category"),
 	}
-	src, err := clientfd.Open()
+	// 请求体超过限制时不读取内容
+	limit := logic.GetUploadPolicy(fields["entityType"]).MaxSize
+	if fields["entityType"] == "" {
+		limit = logic.MaxUploadSize()
+	}
+	if req.ContentLength > limit+multipartOverhead {
+		return common.ErrFileTooLarge
+	}
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, logic.MaxUploadSize()+multipartOverhead)
+	reader, err := req.MultipartReader()
 	if err != nil {
 		return err
 	}
-	defer src.Close()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			// 没有文件
+			return common.ErrBadQueryParams
+		}
+		if err != nil {
+			return err
+		}
+		if part.FormName() != "file" {
+			if _, ok := fields[part.FormName()]; ok {
+				value, err := io.ReadAll(io.LimitReader(part, 1024))
+				if err != nil {
+					return err
+				}
+				fields[part.FormName()] = string(value)
+			}
+			continue
+		}
+
+		fileName := part.FileName()
+		if fields["name"] != "" {
+			fileName = fields["name"]
+		}
+		// 关联对象，也可在保存对象时关联
+		entityID, _ := strconv.Atoi(fields["entityId"])
+
+		// Save
+		file, err := s.lgc.SaveFile(part, fileName, currentUserID(c), fields["entityType"], uint(entityID), fields["category"])
+		if err != nil {
+			return err
+		}
 
-	// Destination
-	destLocalPath := "./temp/"
-	checkAndCreateDir(destLocalPath)
+		return c.JSON(http.StatusOK, common.NewHttpMsgData(echo.Map{
+			"id":       file.ID,
+			"fileName": file.Name,
+			"hash":     file.Hash,
+			"size":     file.Size,
+			"mimeType": file.MimeType,
+		}))
+	}
+}
 
-	fileName := "temp.jpg"
-	if c.FormValue("name") != "" {
-		fileName = c.FormValue("name")
+func (s *service) downloadFile(c echo.Context) error {
+	id := uint(0)
+	// file id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// 缩略图尺寸
+	thumb, _ := strconv.Atoi(c.QueryParam("thumb"))
+	var content *logic.FileContent
+	var err error
+	if sig := c.QueryParam("sig"); sig != "" {
+		expires, _ := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+		content, err = s.lgc.GetSignedFileContent(c.Request().Context(), id, expires, sig, thumb)
+	} else {
+		content, err = s.lgc.GetFileContent(c.Request().Context(), id, currentUserID(c), thumb)
 	}
-	localpath := fmt.Sprintf("%s%s", destLocalPath, fileName)
-	dst, err := os.OpenFile(localpath, os.O_WRONLY|os.O_CREATE, 0666)
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
-
-	// Copy
-	// 利用io.TeeReader在读取文件内容时计算hash值
-	fhash := sha1.New()
-	io.Copy(dst, io.TeeReader(src, fhash))
-	hstr := hex.EncodeToString(fhash.Sum(nil))
-
-	// copy to web dir
-	targetFile := fmt.Sprintf("%s%s", util.FileDir, fileName)
-	checkAndCreateDir(util.FileDir)
-	if _, err := copy(localpath, targetFile); err != nil {
+	// 存储支持时跳转到预签名地址
+	location, err := s.lgc.FileContentURL(content)
+	if err != nil {
 		return err
 	}
-
-	return c.JSON(http.StatusOK, common.NewHttpMsgData(echo.Map{
-		"fileName": fileName,
-		"hash":     hstr,
-	}))
-}
-
-func checkAndCreateDir(path string) {
-	_, err := os.Stat(path)
-	if err != nil && os.IsNotExist(err) {
-		os.MkdirAll(path, 0755)
+	if location != "" {
+		return c.Redirect(http.StatusFound, location)
 	}
-}
-
-func copy(src, dst string) (int64, error) {
-	sourceFileStat, err := os.Stat(src)
+	// 由服务转发内容，文件按内容hash存储，ETag不变
+	reader, err := s.lgc.OpenFileContent(content)
 	if err != nil {
-		return 0, err
+		return err
 	}
+	defer reader.Close()
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, content.MimeType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("inline; filename*=UTF-8''%s", url.PathEscape(content.Name)))
+	header.Set("ETag", content.ETag)
+	header.Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(c.Response(), c.Request(), content.Name, content.ModTime, reader)
+	return nil
+}
 
-	if !sourceFileStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", src)
+func (s *service) deleteFile(c echo.Context) error {
+	id := uint(0)
+	// file id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteFile(id, currentUserID(c)); err != nil {
+		return err
 	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
 
-	source, err := os.Open(src)
+func (s *service) listAttachments(c echo.Context) error {
+	entityID, _ := strconv.Atoi(c.QueryParam("entityId"))
+	data, err := s.lgc.ListAttachments(c.QueryParam("entityType"), uint(entityID), c.QueryParam("category"), currentUserID(c))
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer source.Close()
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
 
-	destination, err := os.Create(dst)
-	if err != nil {
-		return 0, err
+func (s *service) addAttachment(c echo.Context) error {
+	r := new(logic.AttachmentParam)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddAttachment(r, currentUserID(c)); err != nil {
+		return err
 	}
-	defer destination.Close()
-	nBytes, err := io.Copy(destination, source)
-	return nBytes, err
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
 }
@@ -33,6 +33,21 @@ func (s *service) store(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
 }
 
+func (s *service) autoStore(c echo.Context) error {
+	r := new(logic.AutoStoreParam)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	if r.ResID == 0 {
+		return common.ErrBadQueryParams
+	}
+	data, err := s.lgc.AutoStore(r)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
 func (s *service) takeReturn(c echo.Context) error {
 	req, _ := ioutil.ReadAll(c.Request().Body)
 	var data map[string]interface{}
@@ -59,6 +74,7 @@ func (s *service) takeReturnByResID(c echo.Context) error {
 	if err := c.Bind(u); err != nil {
 		return err
 	}
+	u.OperatorID = currentUserID(c)
 	// do
 	if err := s.lgc.TakeReturnByResID(u); err != nil {
 		return err
@@ -66,7 +82,8 @@ func (s *service) takeReturnByResID(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
 }
 
-func (s *service) getResUseLog(c echo.Context) error {
+// useLogQueryParam 取还日志查询条件，列表和报表共用
+func useLogQueryParam(c echo.Context) *logic.UseLogQueryParam {
 	param := &logic.UseLogQueryParam{
 		ResName:       c.QueryParam("resName"),
 		TakeStartTime: c.QueryParam("takeStartTime"),
@@ -78,7 +95,11 @@ func (s *service) getResUseLog(c echo.Context) error {
 	param.ReturnFlag = returnFlag
 	param.TakeStaff = uint(takeStaff)
 	param.ReturnStaff = uint(returnStaff)
+	return param
+}
 
+func (s *service) getResUseLog(c echo.Context) error {
+	param := useLogQueryParam(c)
 	pageIndex, _ := strconv.Atoi(c.QueryParam("pageIndex"))
 	pageSize, _ := strconv.Atoi(c.QueryParam("pageSize"))
 	data, err := s.lgc.GetTakeReturnLog(param, pageIndex, pageSize)
@@ -88,12 +109,73 @@ func (s *service) getResUseLog(c echo.Context) error {
 	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
 }
 
+func (s *service) getMyLoans(c echo.Context) error {
+	data, err := s.lgc.GetUserLoans(currentUserID(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) listBorrowLimits(c echo.Context) error {
+	scope, _ := strconv.Atoi(c.QueryParam("scope"))
+	data, err := s.lgc.ListBorrowLimits(int16(scope))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) addBorrowLimit(c echo.Context) error {
+	r := new(logic.BorrowLimit)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddBorrowLimit(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) updateBorrowLimit(c echo.Context) error {
+	r := new(logic.BorrowLimit)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateBorrowLimit(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) deleteBorrowLimit(c echo.Context) error {
+	id := uint(0)
+	// limit id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteBorrowLimit(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
 func (s *service) registerUsageRoute() {
 	r := s.echo.Group("/res")
 	r.Use(middleware.JWTWithConfig(*s.jwtConfig))
 	// usage
 	r.POST("/store", s.store)
+	r.POST("/store/auto", s.autoStore)
 	r.POST("/take_return", s.takeReturn)
 	r.POST("/take_return_by_res", s.takeReturnByResID)
 	r.GET("/uselog", s.getResUseLog)
+	r.GET("/my_loans", s.getMyLoans)
+	// borrow limit
+	r.GET("/borrow_limits", s.listBorrowLimits)
+	r.POST("/borrow_limit", s.addBorrowLimit)
+	r.PUT("/borrow_limit", s.updateBorrowLimit)
+	r.DELETE("/borrow_limit/:id", s.deleteBorrowLimit)
 }
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"zone.com/common"
+	"zone.com/logic"
+)
+
+func (s *service) listWearThresholds(c echo.Context) error {
+	data, err := s.lgc.ListWearThresholds()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) addWearThreshold(c echo.Context) error {
+	r := new(logic.WearThreshold)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// add
+	if err := s.lgc.AddWearThreshold(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) updateWearThreshold(c echo.Context) error {
+	r := new(logic.WearThreshold)
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	// update
+	if err := s.lgc.UpdateWearThreshold(r); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) deleteWearThreshold(c echo.Context) error {
+	id := uint(0)
+	// threshold id
+	if err := echo.PathParamsBinder(c).Uint("id", &id).BindError(); err != nil {
+		return err
+	}
+	// delete
+	if err := s.lgc.DeleteWearThreshold(id); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData("success"))
+}
+
+func (s *service) listWearAlerts(c echo.Context) error {
+	data, err := s.lgc.ListWearAlerts()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, common.NewHttpMsgData(data))
+}
+
+func (s *service) registerWearRoute() {
+	g := s.echo.Group("/res")
+	g.Use(middleware.JWTWithConfig(*s.jwtConfig))
+	// wear threshold
+	g.GET("/wear_thresholds", s.listWearThresholds)
+	g.POST("/wear_threshold", s.addWearThreshold)
+	g.PUT("/wear_threshold", s.updateWearThreshold)
+	g.DELETE("/wear_threshold/:id", s.deleteWearThreshold)
+	g.GET("/wear_alerts", s.listWearAlerts)
+}
@@ -1,5 +1,7 @@
 package util
 
+import "time"
+
 const (
 	BaseInfo = "ZONE"
 	Group    = "ZONE"
@@ -8,4 +10,37 @@ const (
 var (
 	FileDir   = ""
 	SecretKey = []byte("abcd1234!@#$")
+	// ReservationGrace 预约开始后未取用的宽限时间
+	ReservationGrace = 30 * time.Minute
+	// PDFFont PDF报表字体文件
+	PDFFont = ""
+	// SMTP 定时报表邮件服务器
+	SMTPHost     = ""
+	SMTPPort     = 25
+	SMTPUser     = ""
+	SMTPPassword = ""
+	SMTPFrom     = ""
+	// StorageType 上传文件存储，local或s3
+	StorageType = "local"
+	// StorageDir 本地存储目录，不能位于对外提供的静态文件目录下
+	StorageDir = ""
+	// S3 兼容S3的对象存储
+	S3Endpoint  = ""
+	S3AccessKey = ""
+	S3SecretKey = ""
+	S3Bucket    = ""
+	S3Region    = ""
+	S3UseSSL    = false
+	// S3Presign 下载时跳转到预签名地址，否则由服务转发
+	S3Presign = false
+	// UploadPolicyFile 上传策略JSON文件，按用途覆盖默认策略
+	UploadPolicyFile = ""
+	// MQTT 智能柜设备连接的MQTT服务器，为空时不启用
+	MQTTBroker      = ""
+	MQTTUser        = ""
+	MQTTPassword    = ""
+	MQTTClientID    = ""
+	MQTTTopicPrefix = "zone"
+	// CabinetOfflineAfter 超过该时间没有心跳的智能柜视为离线
+	CabinetOfflineAfter = 5 * time.Minute
 )
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// LeaderLock 基于Postgres会话级咨询锁的主节点选举，多个副本中只有持有锁的一个执行定时任务
+type LeaderLock struct {
+	db   *gorm.DB
+	key  int64
+	conn *sql.Conn
+}
+
+// NewLeaderLock 创建主节点锁
+func NewLeaderLock(db *gorm.DB, key int64) *LeaderLock {
+	return &LeaderLock{db: db, key: key}
+}
+
+// TryLead 尝试成为主节点，已持有锁时检查连接是否有效，连接断开后锁自动释放，需重新获取
+func (l *LeaderLock) TryLead(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+	}
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&locked); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !locked {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Release 释放主节点锁
+func (l *LeaderLock) Release() {
+	if l.conn == nil {
+		return
+	}
+	l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+	l.conn = nil
+}
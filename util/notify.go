@@ -0,0 +1,214 @@
+package util
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Attachment 邮件或Webhook附件
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// SendMail 通过配置的SMTP服务器发送带附件的邮件，端口465使用TLS连接，其他端口在服务器支持时使用STARTTLS
+func SendMail(to []string, subject string, body string, attachments []Attachment) error {
+	if SMTPHost == "" {
+		return errors.New("SMTP server is not configured")
+	}
+	from := SMTPFrom
+	if from == "" {
+		from = SMTPUser
+	}
+
+	var msg bytes.Buffer
+	writer := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(part, []byte(body))
+	for _, a := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=\"%s\"", mime.BEncoding.Encode("UTF-8", a.Filename))},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64(part, a.Data)
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(SMTPHost, strconv.Itoa(SMTPPort))
+	var auth smtp.Auth
+	if SMTPUser != "" {
+		auth = smtp.PlainAuth("", SMTPUser, SMTPPassword, SMTPHost)
+	}
+	if SMTPPort != 465 {
+		return smtp.SendMail(addr, auth, from, to, msg.Bytes())
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: SMTPHost})
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, v := range to {
+		if err := client.Rcpt(v); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// writeBase64 按每行76个字符写入base64内容
+func writeBase64(w interface{ Write([]byte) (int, error) }, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// CheckWebhookURL 检查Webhook地址，只允许http、https协议，主机名为IP时不允许内网地址
+func CheckWebhookURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("webhook url must use http or https")
+	}
+	if u.Hostname() == "" {
+		return errors.New("webhook url has no host")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", ip)
+	}
+	return nil
+}
+
+// publicIP 是否为公网地址，回环、链路本地、内网、组播和未指定地址不允许作为Webhook地址
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsPrivate() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// webhookClient 连接时检查域名解析后的地址，重定向和DNS重绑定也无法访问内网
+var webhookClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+					return fmt.Errorf("webhook address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("too many webhook redirects")
+		}
+		return CheckWebhookURL(req.URL.String())
+	},
+}
+
+// PostWebhook 以multipart/form-data向Webhook地址发送表单字段和附件
+func PostWebhook(rawurl string, fields map[string]string, attachments []Attachment) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	for _, a := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {a.ContentType},
+			"Content-Disposition": {fmt.Sprintf("form-data; name=\"file\"; filename=%q", a.Filename)},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(a.Data); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if err := CheckWebhookURL(rawurl); err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(rawurl, writer.FormDataContentType(), &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}